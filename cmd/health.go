@@ -1,15 +1,73 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"net"
+	"strings"
+
 	"github.com/markpotocki/health/internal/server"
 	"github.com/markpotocki/health/internal/server/store"
 )
 
 func main() {
+	addr := flag.String("addr", server.DefaultAddr, "address to listen on")
+	advertise := flag.String("advertise", "", "host:port the other cluster members reach this server on")
+	peers := flag.String("peers", "", "comma separated host:port of the other cluster members")
+	clusterSecret := flag.String("cluster-secret", "", "secret shared by every cluster member, required with -peers")
+	authToken := flag.String("auth-token", "", "bearer token required to manage upstreams and maintenance over http, and to register probes or urls at another host")
+	upstreams := flag.String("upstreams", "", "comma separated name=url of child aidi servers to federate")
+	targets := flag.String("targets", "", "json file of static scrape targets, watched for changes")
+	catalog := flag.String("catalog", "", "json service catalog file of scrape targets, watched for changes")
+	srvRecord := flag.String("srv", "", "dns srv record to discover scrape targets from, as _service._proto.domain")
+	flag.Parse()
+
 	cs := store.MakeClientStore()
 	ss := store.MakeStatusStore()
 
 	srv := server.MakeServer(cs, ss)
+	srv.SetAddr(*addr)
+	srv.SetAuthToken(*authToken)
+	if *peers != "" {
+		self := *advertise
+		if self == "" {
+			self = *addr
+		}
+		// peers must be able to reach the address, and it names this member's clients
+		if host, _, err := net.SplitHostPort(self); err != nil || host == "" {
+			log.Fatalf("health: -advertise must be the host:port the other cluster members reach this server on, got %q", self)
+		}
+		if *clusterSecret == "" {
+			log.Fatalf("health: -cluster-secret is required with -peers")
+		}
+		srv.JoinCluster(server.MakeCluster(self, strings.Split(*peers, ","), *clusterSecret))
+	}
+
+	if *upstreams != "" {
+		for _, upstream := range strings.Split(*upstreams, ",") {
+			parts := strings.SplitN(upstream, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("health: upstream %q is not name=url", upstream)
+			}
+			if err := srv.AddUpstream(server.Upstream{Name: parts[0], URL: parts[1]}); err != nil {
+				log.Fatalf("health: %v", err)
+			}
+		}
+	}
+
+	if *targets != "" {
+		srv.AddDiscoverer(server.MakeFileDiscoverer(*targets))
+	}
+	if *catalog != "" {
+		srv.AddDiscoverer(server.MakeCatalogDiscoverer(*catalog))
+	}
+	if *srvRecord != "" {
+		parts := strings.SplitN(*srvRecord, ".", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[0], "_") || !strings.HasPrefix(parts[1], "_") {
+			log.Fatalf("health: srv record %q is not _service._proto.domain", *srvRecord)
+		}
+		srv.AddDiscoverer(server.MakeSRVDiscoverer(parts[0][1:], parts[1][1:], parts[2], ""))
+	}
 
 	srv.Start()
 }
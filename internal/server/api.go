@@ -0,0 +1,361 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// Bounds of the page size of list endpoints.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Fields each list can be sorted by.
+var (
+	clientSortFields  = []string{"id", "name", "instance", "origin"}
+	statusSortFields  = []string{"id", "name", "instance", "updated", "down"}
+	serviceSortFields = []string{"name", "instances", "up", "down", "updated"}
+)
+
+// ServiceList is a page of /aidi/v1/services.
+type ServiceList struct {
+	Items []ServiceSummary `json:"items"`
+	Page  models.Page      `json:"page"`
+}
+
+// apiV1 routes the /aidi/v1 api, which answers every failure with a models.ErrorResponse and
+// describes itself on /aidi/v1/openapi.json.
+func (srv *Server) apiV1() http.Handler {
+	rt := &router{}
+	listParams := func(sortFields []string) []param {
+		return []param{selectorParam, sortParam(sortFields), limitParam, offsetParam}
+	}
+
+	rt.handle("POST", "/aidi/v1/register", operation{
+		id:        "register",
+		summary:   "Register a client to be polled",
+		body:      models.RegisterRequest{},
+		responses: map[int]interface{}{http.StatusCreated: models.RegisterResponse{}},
+	}, srv.registerV1Handler)
+	rt.handle("GET", "/aidi/v1/ready", operation{
+		id:        "ready",
+		summary:   "Report whether the server and its dependencies are usable",
+		responses: map[int]interface{}{http.StatusOK: ReadyResponse{}, http.StatusServiceUnavailable: ReadyResponse{}},
+	}, srv.readyHandler)
+	rt.handle("GET", "/aidi/v1/clients", operation{
+		id:        "listClients",
+		summary:   "List the registered and discovered clients",
+		params:    listParams(clientSortFields),
+		responses: map[int]interface{}{http.StatusOK: models.ClientList{}},
+	}, srv.listClientsV1)
+	rt.handle("GET", "/aidi/v1/clients/{id}", operation{
+		id:        "getClient",
+		summary:   "Get a client by id",
+		responses: map[int]interface{}{http.StatusOK: models.ClientInfo{}},
+	}, srv.getClientV1)
+	rt.handle("GET", "/aidi/v1/statuses", operation{
+		id:        "listStatuses",
+		summary:   "List the latest status of every client",
+		params:    append(listParams(statusSortFields), pollParam),
+		responses: map[int]interface{}{http.StatusOK: models.StatusList{}},
+	}, srv.listStatusesV1)
+	rt.handle("GET", "/aidi/v1/statuses/{id}", operation{
+		id:        "getStatus",
+		summary:   "Get the latest status of a client by id",
+		responses: map[int]interface{}{http.StatusOK: models.ClientStatus{}},
+	}, srv.getStatusV1)
+	rt.handle("GET", "/aidi/v1/services", operation{
+		id:        "listServices",
+		summary:   "List a summary of every service",
+		params:    listParams(serviceSortFields),
+		responses: map[int]interface{}{http.StatusOK: ServiceList{}},
+	}, srv.listServicesV1)
+	rt.handle("GET", "/aidi/v1/services/{name}", operation{
+		id:        "getService",
+		summary:   "Get the summary of a service by name",
+		responses: map[int]interface{}{http.StatusOK: ServiceSummary{}},
+	}, srv.getServiceV1)
+	rt.handle("GET", "/aidi/v1/maintenance", operation{
+		id:        "listMaintenance",
+		summary:   "List the maintenance windows that are open or still to come",
+		params:    []param{sortParam(maintenanceSortFields), limitParam, offsetParam},
+		responses: map[int]interface{}{http.StatusOK: models.MaintenanceList{}},
+	}, srv.listMaintenanceV1)
+	rt.handle("POST", "/aidi/v1/maintenance", operation{
+		id:        "createMaintenance",
+		summary:   "Schedule a maintenance window for a client or the clients matching a selector",
+		body:      models.MaintenanceRequest{},
+		responses: map[int]interface{}{http.StatusCreated: models.Maintenance{}},
+	}, srv.createMaintenanceV1)
+	rt.handle("GET", "/aidi/v1/maintenance/{id}", operation{
+		id:        "getMaintenance",
+		summary:   "Get a maintenance window by id",
+		responses: map[int]interface{}{http.StatusOK: models.Maintenance{}},
+	}, srv.getMaintenanceV1)
+	rt.handle("DELETE", "/aidi/v1/maintenance/{id}", operation{
+		id:        "deleteMaintenance",
+		summary:   "Cancel a maintenance window",
+		responses: map[int]interface{}{http.StatusNoContent: nil},
+	}, srv.deleteMaintenanceV1)
+
+	var spec map[string]interface{}
+	rt.handle("GET", "/aidi/v1/openapi.json", operation{
+		id:        "openapi",
+		summary:   "Get this OpenAPI document",
+		responses: map[int]interface{}{http.StatusOK: map[string]interface{}{}},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, spec)
+	})
+	spec = openAPI(rt.routes)
+
+	return rt
+}
+
+// clientStatus is hs as served by the versioned api.
+func clientStatus(hs HealthStatus) models.ClientStatus {
+	return models.ClientStatus{
+		ID:          hs.ID(),
+		Name:        hs.ClientName,
+		Instance:    hs.Instance,
+		Health:      hs.Data,
+		Updated:     hs.Updated,
+		Origin:      hs.Origin,
+		Labels:      hs.Labels,
+		Metadata:    hs.Metadata,
+		Maintenance: hs.Maintenance,
+	}
+}
+
+// listQuery is the selector, order and page asked for by a call to a list endpoint.
+type listQuery struct {
+	selector models.Selector
+	sort     string
+	desc     bool
+	offset   int
+	limit    int
+}
+
+// parseListQuery reads ?selector=, ?sort=, ?limit= and ?offset=, answering 400 with every
+// bad parameter when any does not parse. Lists are sorted by the first of sortFields unless
+// asked otherwise.
+func parseListQuery(w http.ResponseWriter, r *http.Request, sortFields []string) (listQuery, bool) {
+	query := r.URL.Query()
+	q := listQuery{sort: sortFields[0], limit: defaultLimit}
+	errs := []models.FieldError{}
+
+	sel, err := models.ParseSelector(query.Get("selector"))
+	if err != nil {
+		errs = append(errs, models.FieldError{Field: "selector", Message: err.Error()})
+	}
+	q.selector = sel
+
+	if field := query.Get("sort"); field != "" {
+		q.desc = strings.HasPrefix(field, "-")
+		q.sort = strings.TrimPrefix(field, "-")
+		known := false
+		for _, f := range sortFields {
+			known = known || f == q.sort
+		}
+		if !known {
+			errs = append(errs, models.FieldError{Field: "sort", Message: "must be one of " + strings.Join(sortFields, ", ")})
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if q.limit, err = strconv.Atoi(limit); err != nil || q.limit < 1 || q.limit > maxLimit {
+			errs = append(errs, models.FieldError{Field: "limit", Message: "must be between 1 and " + strconv.Itoa(maxLimit)})
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if q.offset, err = strconv.Atoi(offset); err != nil || q.offset < 0 {
+			errs = append(errs, models.FieldError{Field: "offset", Message: "must be zero or more"})
+		}
+	}
+
+	if len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "invalid query", Fields: errs})
+		return q, false
+	}
+	return q, true
+}
+
+// sortItems orders items, a slice, by the query's sort field and then by the first sort
+// field to keep pages stable. field reads the named field of the i'th item.
+func (q listQuery) sortItems(items interface{}, tiebreak string, field func(i int, name string) interface{}) {
+	sort.SliceStable(items, func(i, j int) bool {
+		c := compare(field(i, q.sort), field(j, q.sort))
+		if q.desc {
+			c = -c
+		}
+		if c == 0 {
+			return compare(field(i, tiebreak), field(j, tiebreak)) < 0
+		}
+		return c < 0
+	})
+}
+
+func compare(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int:
+		return compareInt(int64(a), int64(b.(int)))
+	case int64:
+		return compareInt(a, b.(int64))
+	case bool:
+		return compareInt(int64(boolInt(a)), int64(boolInt(b.(bool))))
+	}
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// page is the range of a list of total items to return.
+func (q listQuery) page(total int) (start, end int, page models.Page) {
+	start, end = q.offset, q.offset+q.limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return start, end, models.Page{Total: total, Offset: q.offset, Limit: q.limit}
+}
+
+func notFound(w http.ResponseWriter, what, id string) {
+	writeError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "could not find " + what + " " + id})
+}
+
+func (srv *Server) listClientsV1(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r, clientSortFields)
+	if !ok {
+		return
+	}
+	clients := srv.selectClients(q.selector)
+	if clients == nil {
+		clients = []models.ClientInfo{}
+	}
+	q.sortItems(clients, "id", func(i int, name string) interface{} {
+		switch name {
+		case "name":
+			return clients[i].Name()
+		case "instance":
+			return clients[i].Instance
+		case "origin":
+			return clients[i].Origin
+		}
+		return clients[i].ID()
+	})
+
+	start, end, page := q.page(len(clients))
+	writeJSON(w, http.StatusOK, models.ClientList{Items: clients[start:end], Page: page})
+}
+
+func (srv *Server) getClientV1(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	for _, info := range srv.clientStore.Get() {
+		if info.ID() == id {
+			writeJSON(w, http.StatusOK, info)
+			return
+		}
+	}
+	notFound(w, "client", id)
+}
+
+// listStatusesV1 lists the latest statuses. With ?poll=true the response is held until the
+// next round of pings has been saved.
+func (srv *Server) listStatusesV1(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r, statusSortFields)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("poll") == "true" {
+		if err := longPoll(r.Context(), srv); err != nil {
+			return
+		}
+	}
+	statuses := make([]models.ClientStatus, 0)
+	for _, hs := range srv.selectStatuses(q.selector) {
+		statuses = append(statuses, clientStatus(hs))
+	}
+	q.sortItems(statuses, "id", func(i int, name string) interface{} {
+		switch name {
+		case "name":
+			return statuses[i].Name
+		case "instance":
+			return statuses[i].Instance
+		case "updated":
+			return statuses[i].Updated
+		case "down":
+			return statuses[i].Health.Down
+		}
+		return statuses[i].ID
+	})
+
+	start, end, page := q.page(len(statuses))
+	writeJSON(w, http.StatusOK, models.StatusList{Items: statuses[start:end], Page: page})
+}
+
+func (srv *Server) getStatusV1(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	hs, err := srv.statusStore.Find(id)
+	if err != nil {
+		notFound(w, "status of client", id)
+		return
+	}
+	writeJSON(w, http.StatusOK, clientStatus(hs))
+}
+
+func (srv *Server) listServicesV1(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r, serviceSortFields)
+	if !ok {
+		return
+	}
+	summaries := summarize(srv.selectStatuses(q.selector))
+	q.sortItems(summaries, "name", func(i int, name string) interface{} {
+		switch name {
+		case "instances":
+			return summaries[i].Instances
+		case "up":
+			return summaries[i].Up
+		case "down":
+			return summaries[i].Down
+		case "updated":
+			return summaries[i].Updated
+		}
+		return summaries[i].Name
+	})
+
+	start, end, page := q.page(len(summaries))
+	writeJSON(w, http.StatusOK, ServiceList{Items: summaries[start:end], Page: page})
+}
+
+func (srv *Server) getServiceV1(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "name")
+	for _, summary := range summarize(srv.statusStore.FindAll()) {
+		if summary.Name == name {
+			writeJSON(w, http.StatusOK, summary)
+			return
+		}
+	}
+	notFound(w, "service", name)
+}
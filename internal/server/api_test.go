@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/internal/status"
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func apiGet(api http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	if body != nil {
+		json.NewDecoder(recorder.Body).Decode(body)
+	}
+	return recorder
+}
+
+func TestAPIPaging(t *testing.T) {
+	cs, ss := &memClientStore{}, &memStatusStore{}
+	for i, name := range []string{"carts", "orders", "payments", "search", "users"} {
+		cs.Save(models.ClientInfo{CName: name, CPort: 8080, Labels: map[string]string{"env": "prod"}})
+		ss.Save(HealthStatus{ClientName: name, Updated: int64(100 - i), Labels: map[string]string{"env": "prod"}})
+	}
+	cs.Save(models.ClientInfo{CName: "staging", CPort: 8080, Labels: map[string]string{"env": "staging"}})
+	api := MakeServer(cs, ss).apiV1()
+
+	clients := models.ClientList{}
+	apiGet(api, "GET", "/aidi/v1/clients?selector=env%3Dprod&sort=-name&limit=2&offset=1", &clients)
+	names := []string{}
+	for _, info := range clients.Items {
+		names = append(names, info.Name())
+	}
+	if strings.Join(names, ",") != "search,payments" || clients.Page != (models.Page{Total: 5, Offset: 1, Limit: 2}) {
+		t.Errorf("wanted search,payments of 5, got %v %+v", names, clients.Page)
+	}
+
+	statuses := models.StatusList{}
+	apiGet(api, "GET", "/aidi/v1/statuses?sort=updated&limit=1", &statuses)
+	if len(statuses.Items) != 1 || statuses.Items[0].Name != "users" || statuses.Page.Total != 5 {
+		t.Errorf("wanted oldest status first, got %+v", statuses)
+	}
+
+	// past the end is an empty page rather than an error
+	services := ServiceList{}
+	recorder := apiGet(api, "GET", "/aidi/v1/services?offset=10", &services)
+	if recorder.Code != http.StatusOK || services.Items == nil || len(services.Items) != 0 || services.Page.Total != 5 {
+		t.Errorf("wanted empty page, got %d %+v", recorder.Code, services)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	api := MakeServer(&memClientStore{}, &memStatusStore{}).apiV1()
+	cases := []struct {
+		method, path string
+		status       int
+		code         string
+		fields       string
+	}{
+		{"GET", "/aidi/v1/clients?limit=0&offset=-1&sort=port&selector=%3Dprod", http.StatusBadRequest, models.ErrCodeBadRequest, "selector,sort,limit,offset"},
+		{"GET", "/aidi/v1/clients/orders@10.0.0.5:8080", http.StatusNotFound, models.ErrCodeNotFound, ""},
+		{"GET", "/aidi/v1/statuses/orders", http.StatusNotFound, models.ErrCodeNotFound, ""},
+		{"GET", "/aidi/v1/services/orders", http.StatusNotFound, models.ErrCodeNotFound, ""},
+		{"GET", "/aidi/v1/nothing", http.StatusNotFound, models.ErrCodeNotFound, ""},
+		{"DELETE", "/aidi/v1/clients", http.StatusMethodNotAllowed, models.ErrCodeMethodNotAllowed, ""},
+		{"GET", "/aidi/v1/register", http.StatusMethodNotAllowed, models.ErrCodeMethodNotAllowed, ""},
+	}
+	for _, c := range cases {
+		resp := models.ErrorResponse{}
+		recorder := apiGet(api, c.method, c.path, &resp)
+		fields := []string{}
+		for _, field := range resp.Error.Fields {
+			fields = append(fields, field.Field)
+		}
+		if recorder.Code != c.status || resp.Error.Code != c.code || strings.Join(fields, ",") != c.fields {
+			t.Errorf("%s %s: wanted %d %s on %q, got %d %+v", c.method, c.path, c.status, c.code, c.fields, recorder.Code, resp.Error)
+		}
+		if ct := recorder.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: wanted json error, got %q", c.method, c.path, ct)
+		}
+	}
+
+	recorder := apiGet(api, "PUT", "/aidi/v1/register", nil)
+	if allow := recorder.Header().Get("Allow"); allow != "POST" {
+		t.Errorf("wanted Allow: POST, got %q", allow)
+	}
+}
+
+func TestAPIPathParams(t *testing.T) {
+	cs, ss := &memClientStore{}, &memStatusStore{}
+	cs.Save(models.ClientInfo{CName: "orders", Instance: "[fd00::1]:8080", CPort: 8080})
+	ss.Save(HealthStatus{ClientName: "orders", Instance: "[fd00::1]:8080"})
+	api := MakeServer(cs, ss).apiV1()
+
+	info := models.ClientInfo{}
+	if recorder := apiGet(api, "GET", "/aidi/v1/clients/orders@%5Bfd00::1%5D:8080", &info); recorder.Code != http.StatusOK || info.ID() != "orders@[fd00::1]:8080" {
+		t.Errorf("wanted client by escaped id, got %d %+v", recorder.Code, info)
+	}
+	status := models.ClientStatus{}
+	if recorder := apiGet(api, "GET", "/aidi/v1/statuses/orders@[fd00::1]:8080", &status); recorder.Code != http.StatusOK || status.ID != "orders@[fd00::1]:8080" {
+		t.Errorf("wanted status by id, got %d %+v", recorder.Code, status)
+	}
+}
+
+func TestAPITimedByRoute(t *testing.T) {
+	api := MakeServer(&memClientStore{}, &memStatusStore{}).apiV1()
+	before := notFoundCounts()
+	for _, path := range []string{"/aidi/v1/clients/a", "/aidi/v1/clients/b", "/aidi/v1/nothing/a", "/aidi/v1/nothing/b"} {
+		apiGet(api, "GET", path, nil)
+	}
+
+	counts := notFoundCounts()
+	if counts["/aidi/v1/clients/{id}"]-before["/aidi/v1/clients/{id}"] != 2 || counts[unmatchedRoute]-before[unmatchedRoute] != 2 {
+		t.Errorf("wanted requests counted by route, got %v", counts)
+	}
+	for route := range counts {
+		if strings.HasSuffix(route, "/a") || strings.HasSuffix(route, "/b") {
+			t.Errorf("wanted no route per path, got %s", route)
+		}
+	}
+}
+
+// notFoundCounts is how many GET requests have been answered 4xx, by route.
+func notFoundCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	for _, count := range status.GlobalRequestTracker.Counts() {
+		if count.Method == "GET" && count.Class == "4xx" {
+			counts[count.Route] += count.Count
+		}
+	}
+	return counts
+}
+
+func TestAPIPoll(t *testing.T) {
+	ss := &memStatusStore{}
+	srv := MakeServer(&memClientStore{}, ss)
+	api := srv.apiV1()
+	waiting := func() int {
+		n := 0
+		srv.connections.Range(func(key, val interface{}) bool { n++; return true })
+		return n
+	}
+
+	done := make(chan models.StatusList)
+	go func() {
+		statuses := models.StatusList{}
+		apiGet(api, "GET", "/aidi/v1/statuses?poll=true", &statuses)
+		done <- statuses
+	}()
+	for waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ss.Save(HealthStatus{ClientName: "orders"})
+	srv.pingAll()
+	if statuses := <-done; len(statuses.Items) != 1 {
+		t.Errorf("wanted the status saved before the round, got %+v", statuses)
+	}
+
+	// a caller that goes away stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/aidi/v1/statuses?poll=true", nil).WithContext(ctx))
+		close(done)
+	}()
+	for waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if n := waiting(); n != 0 {
+		t.Errorf("wanted no callers left waiting, got %d", n)
+	}
+}
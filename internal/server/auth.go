@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// SetAuthToken sets the bearer token callers must send, as "Authorization: Bearer token", to
+// manage upstreams over http, to register probes or urls at a host other than the caller's,
+// or to schedule and cancel maintenance. Without one all of these are refused and only
+// configuration or discovery can add them. It must be called before Start.
+func (srv *Server) SetAuthToken(token string) {
+	srv.authToken = token
+}
+
+// authorized reports whether r carries the server's auth token. It is always false when no
+// token is set.
+func (srv *Server) authorized(r *http.Request) bool {
+	if srv.authToken == "" {
+		return false
+	}
+	expect := "Bearer " + srv.authToken
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expect)) == 1
+}
+
+// requireAuth answers 401 unless r is authorized.
+func (srv *Server) requireAuth(w http.ResponseWriter, r *http.Request) bool {
+	if srv.authorized(r) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// requireAuthV1 is requireAuth for the v1 API, answering with an APIError that explains what
+// needed the token.
+func (srv *Server) requireAuthV1(w http.ResponseWriter, r *http.Request, message string) bool {
+	if srv.authorized(r) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeError(w, http.StatusUnauthorized, models.APIError{Code: models.ErrCodeUnauthorized, Message: message})
+	return false
+}
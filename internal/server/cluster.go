@@ -0,0 +1,325 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// memberTimeout is how long a peer can go without syncing before it is considered dead and
+// its clients are polled by the remaining members. Heartbeats are sent apart from polling,
+// but it is kept longer than a whole round so a busy member is never taken for dead.
+const memberTimeout = roundTimeout + 3*pollInterval
+
+// clusterSecretHeader carries the shared secret on every sync between members.
+const clusterSecretHeader = "X-Aidi-Cluster-Secret"
+
+// virtualNodes is how many points each member gets on the hash ring, which evens out the
+// share of clients each member polls.
+const virtualNodes = 64
+
+// Cluster is a static group of aidi servers sharing their registrations. Every member pushes
+// its clients, and the statuses it has polled, to its peers after each round of polling,
+// and a heartbeat on its own each poll interval so a slow round does not look like the
+// member has died. Clients are split between live members with consistent hashing
+// so each client is polled by one member, and a member that stops syncing has its clients
+// taken over by the others.
+//
+// Syncs are only taken from the configured peers: they must carry the cluster's secret and
+// come from an address the peer's host resolves to.
+type Cluster struct {
+	self   string
+	peers  []string
+	secret string
+	seen   map[string]time.Time
+	now    func() time.Time
+	lookup func(host string) ([]string, error)
+	mutex  sync.Mutex
+}
+
+// MakeCluster provides a Cluster for the member reachable at self, as "host:port", with the
+// other members at peers. Every member should be started with the same set of addresses and
+// the same secret; without a secret every sync is refused.
+func MakeCluster(self string, peers []string, secret string) *Cluster {
+	others := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if peer != "" && peer != self {
+			others = append(others, peer)
+		}
+	}
+	return &Cluster{
+		self:   self,
+		peers:  others,
+		secret: secret,
+		seen:   make(map[string]time.Time),
+		now:    time.Now,
+		lookup: net.LookupHost,
+	}
+}
+
+// ClusterMember is the state of one member as seen from this server.
+type ClusterMember struct {
+	Addr     string    `json:"addr"`
+	Self     bool      `json:"self"`
+	Alive    bool      `json:"alive"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+}
+
+// syncMessage is the body a member pushes to its peers.
+type syncMessage struct {
+	Member   string              `json:"member"`
+	Clients  []models.ClientInfo `json:"clients"`
+	Statuses []HealthStatus      `json:"statuses"`
+
+	Maintenance []models.Maintenance `json:"maintenance,omitempty"`
+}
+
+// Self returns the address this member is known by.
+func (c *Cluster) Self() string {
+	return c.self
+}
+
+// State reports every member of the cluster, this one first.
+func (c *Cluster) State() []ClusterMember {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	ret := []ClusterMember{{Addr: c.self, Self: true, Alive: true, LastSeen: now}}
+	for _, peer := range c.peers {
+		seen := c.seen[peer]
+		ret = append(ret, ClusterMember{
+			Addr:     peer,
+			Alive:    !seen.IsZero() && now.Sub(seen) < memberTimeout,
+			LastSeen: seen,
+		})
+	}
+	return ret
+}
+
+// Members returns the address of every live member, including this one, sorted.
+func (c *Cluster) Members() []string {
+	ret := make([]string, 0, len(c.peers)+1)
+	for _, member := range c.State() {
+		if member.Alive {
+			ret = append(ret, member.Addr)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Owned filters clients down to the ones this member should poll.
+func (c *Cluster) Owned(clients []models.ClientInfo) []models.ClientInfo {
+	ring := makeHashRing(c.Members())
+	ret := make([]models.ClientInfo, 0, len(clients))
+	for _, cli := range clients {
+		if ring.owner(cli.ID()) == c.self {
+			ret = append(ret, cli)
+		}
+	}
+	return ret
+}
+
+// verify checks that r, a sync from member, comes from a configured peer.
+func (c *Cluster) verify(r *http.Request, member string) error {
+	if c.secret == "" {
+		return errors.New("no cluster secret is set")
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(clusterSecretHeader)), []byte(c.secret)) != 1 {
+		return errors.New("wrong cluster secret")
+	}
+
+	known := false
+	for _, peer := range c.peers {
+		known = known || peer == member
+	}
+	if !known {
+		return fmt.Errorf("%s is not a member", member)
+	}
+
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	host, _, err := net.SplitHostPort(member)
+	if err != nil {
+		return err
+	}
+	addrs := []string{host}
+	if net.ParseIP(host) == nil {
+		if addrs, err = c.lookup(host); err != nil {
+			return err
+		}
+	}
+	remoteIP := net.ParseIP(remote)
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.Equal(remoteIP) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not resolve to %s", member, remote)
+}
+
+func (c *Cluster) alive(member string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, peer := range c.peers {
+		if peer == member {
+			c.seen[member] = c.now()
+			return
+		}
+	}
+	log.Printf("cluster: ignoring sync from unknown member %s", member)
+}
+
+// Run sends a heartbeat to every peer each interval, never returning.
+func (c *Cluster) Run(interval time.Duration) {
+	for {
+		c.push(syncMessage{})
+		time.Sleep(interval)
+	}
+}
+
+// push sends msg to every peer at once. Peers that cannot be reached are logged and
+// skipped; they catch up on the next sync after they return.
+func (c *Cluster) push(msg syncMessage) {
+	msg.Member = c.self
+	body, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("cluster: could not encode sync -- %v", err)
+		return
+	}
+	wg := sync.WaitGroup{}
+	for _, peer := range c.peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/aidi/cluster/sync", peer), bytes.NewReader(body))
+			if err != nil {
+				log.Printf("cluster: could not sync with %s -- %v", peer, err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(clusterSecretHeader, c.secret)
+			resp, err := httpcli.Do(req)
+			if err != nil {
+				log.Printf("cluster: could not sync with %s -- %v", peer, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				log.Printf("cluster: sync with %s got status %d", peer, resp.StatusCode)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// hashRing maps keys onto members with consistent hashing, so a member joining or leaving
+// only moves the keys it owned.
+type hashRing struct {
+	points  []uint32
+	members map[uint32]string
+}
+
+func makeHashRing(members []string) hashRing {
+	ring := hashRing{members: make(map[uint32]string)}
+	for _, member := range members {
+		for i := 0; i < virtualNodes; i++ {
+			point := hashKey(member + "#" + strconv.Itoa(i))
+			ring.points = append(ring.points, point)
+			ring.members[point] = member
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// owner returns the member owning key, the first point clockwise of its hash.
+func (ring hashRing) owner(key string) string {
+	if len(ring.points) == 0 {
+		return ""
+	}
+	hash := hashKey(key)
+	i := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= hash })
+	if i == len(ring.points) {
+		i = 0
+	}
+	return ring.members[ring.points[i]]
+}
+
+// hashKey is fnv-1a followed by the murmur3 finalizer, as fnv alone clusters keys that only
+// differ in their last characters, like "client-1" and "client-2".
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// clusterHandler reports the cluster members as seen by this server.
+func (srv *Server) clusterHandler(w http.ResponseWriter, r *http.Request) {
+	if srv.cluster == nil {
+		http.Error(w, "server is not clustered", http.StatusNotFound)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(srv.cluster.State()); err != nil {
+		log.Printf("server: encountered error encoding json: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+// syncHandler takes in the registrations and statuses pushed by a peer, refusing syncs
+// that do not come from one.
+func (srv *Server) syncHandler(w http.ResponseWriter, r *http.Request) {
+	if srv.cluster == nil {
+		http.Error(w, "server is not clustered", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	msg := syncMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		log.Printf("server: bad sync recieved %v", err)
+		http.Error(w, "not expected json", http.StatusBadRequest)
+		return
+	}
+	if err := srv.cluster.verify(r, msg.Member); err != nil {
+		log.Printf("server: refused sync from %s -- %v", r.RemoteAddr, err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	srv.cluster.alive(msg.Member)
+
+	for _, info := range msg.Clients {
+		srv.clientStore.Save(info)
+	}
+	for _, hs := range msg.Statuses {
+		// a status polled before ownership moved must not replace a newer one
+		if current, err := srv.statusStore.Find(hs.ID()); err == nil && current.Updated > hs.Updated {
+			continue
+		}
+		srv.statusStore.Save(hs)
+	}
+	srv.mergeMaintenance(msg.Maintenance)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestHashRing(t *testing.T) {
+	before := makeHashRing([]string{"a:9900", "b:9900", "c:9900"})
+	after := makeHashRing([]string{"a:9900", "c:9900"})
+
+	owned := map[string]int{}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		owner := before.owner(key)
+		owned[owner]++
+		// only the keys of the member that left may move
+		if owner != "b:9900" && after.owner(key) != owner {
+			t.Errorf("%s moved from %s to %s", key, owner, after.owner(key))
+		}
+	}
+	for _, member := range []string{"a:9900", "b:9900", "c:9900"} {
+		if owned[member] < 50 {
+			t.Errorf("%s: wanted a fair share of 300 clients, got %d", member, owned[member])
+		}
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	now := time.Unix(1000, 0)
+	cluster := MakeCluster("a:9900", []string{"a:9900", "b:9900"}, "secret")
+	cluster.now = func() time.Time { return now }
+
+	clients := make([]models.ClientInfo, 0, 100)
+	for i := 0; i < 100; i++ {
+		clients = append(clients, models.ClientInfo{CName: fmt.Sprintf("client-%d", i)})
+	}
+
+	// b has not synced yet so a polls everything
+	if owned := len(cluster.Owned(clients)); owned != 100 {
+		t.Errorf("alone: wanted all 100 clients, got %d", owned)
+	}
+
+	cluster.alive("b:9900")
+	if owned := len(cluster.Owned(clients)); owned == 0 || owned == 100 {
+		t.Errorf("joined: wanted clients split with b, got %d", owned)
+	}
+
+	// b stops syncing
+	now = now.Add(memberTimeout)
+	if owned := len(cluster.Owned(clients)); owned != 100 {
+		t.Errorf("b dead: wanted all 100 clients back, got %d", owned)
+	}
+	if state := cluster.State(); len(state) != 2 || state[1].Alive {
+		t.Errorf("wanted b reported dead, got %+v", state)
+	}
+}
+
+func TestSyncHandler(t *testing.T) {
+	cs, ss := &memClientStore{}, &memStatusStore{}
+	ss.Save(HealthStatus{ClientName: "fresh", Updated: 10})
+	srv := MakeServer(cs, ss)
+	srv.JoinCluster(MakeCluster("a:9900", []string{"b:9900"}, "secret"))
+	srv.cluster.lookup = func(host string) ([]string, error) {
+		return map[string][]string{"b": {"10.0.0.2"}}[host], nil
+	}
+
+	body, _ := json.Marshal(syncMessage{
+		Member:  "b:9900",
+		Clients: []models.ClientInfo{{CName: "orders", CURL: "http://orders"}},
+		Statuses: []HealthStatus{
+			{ClientName: "orders", Updated: 5},
+			{ClientName: "fresh", Updated: 5},
+		},
+	})
+	recorder := syncFrom(srv, "10.0.0.2:41000", "secret", body)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("wanted 204, got %d", recorder.Code)
+	}
+	if clients := cs.Get(); len(clients) != 1 || clients[0].URL() != "http://orders" {
+		t.Errorf("wanted orders registration replicated, got %+v", clients)
+	}
+	if hs, err := ss.Find("orders"); err != nil || hs.Updated != 5 {
+		t.Errorf("wanted orders status replicated, got %+v %v", hs, err)
+	}
+	if hs, _ := ss.Find("fresh"); hs.Updated != 10 {
+		t.Errorf("wanted newer status kept, got %+v", hs)
+	}
+	if members := srv.cluster.Members(); len(members) != 2 {
+		t.Errorf("wanted b alive after syncing, got %v", members)
+	}
+}
+
+func TestSyncHandlerRefuses(t *testing.T) {
+	cases := []struct {
+		name, member, remote, secret string
+	}{
+		{"no-secret", "b:9900", "10.0.0.2:41000", ""},
+		{"wrong-secret", "b:9900", "10.0.0.2:41000", "wrong"},
+		{"unknown-member", "c:9900", "10.0.0.2:41000", "secret"},
+		{"wrong-source", "b:9900", "10.0.0.3:41000", "secret"},
+	}
+	for _, c := range cases {
+		cs := &memClientStore{}
+		srv := MakeServer(cs, &memStatusStore{})
+		srv.JoinCluster(MakeCluster("a:9900", []string{"b:9900"}, "secret"))
+		srv.cluster.lookup = func(host string) ([]string, error) {
+			return map[string][]string{"b": {"10.0.0.2"}, "c": {"10.0.0.2"}}[host], nil
+		}
+
+		body, _ := json.Marshal(syncMessage{Member: c.member, Clients: []models.ClientInfo{{CName: "orders", CURL: "http://orders"}}})
+		recorder := syncFrom(srv, c.remote, c.secret, body)
+		if recorder.Code != http.StatusForbidden || len(cs.Get()) != 0 || len(srv.cluster.Members()) != 1 {
+			t.Errorf("%s: wanted sync refused, got %d %+v", c.name, recorder.Code, cs.Get())
+		}
+	}
+
+	// a member without a secret takes no syncs at all
+	srv := MakeServer(&memClientStore{}, &memStatusStore{})
+	srv.JoinCluster(MakeCluster("a:9900", []string{"10.0.0.2:9900"}, ""))
+	body, _ := json.Marshal(syncMessage{Member: "10.0.0.2:9900"})
+	if recorder := syncFrom(srv, "10.0.0.2:41000", "", body); recorder.Code != http.StatusForbidden {
+		t.Errorf("wanted sync refused without a secret set, got %d", recorder.Code)
+	}
+}
+
+func syncFrom(srv *Server, remoteAddr, secret string, body []byte) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/aidi/cluster/sync", bytes.NewReader(body))
+	request.RemoteAddr = remoteAddr
+	request.Header.Set(clusterSecretHeader, secret)
+	srv.syncHandler(recorder, request)
+	return recorder
+}
+
+func TestRegistrationReplicated(t *testing.T) {
+	peerStore := &memClientStore{}
+	peer := MakeServer(peerStore, &memStatusStore{})
+	peerHTTP := httptest.NewServer(http.HandlerFunc(peer.syncHandler))
+	defer peerHTTP.Close()
+
+	srv := MakeServer(&memClientStore{}, &memStatusStore{})
+	self := "127.0.0.1:9900"
+	srv.JoinCluster(MakeCluster(self, []string{peerHTTP.Listener.Addr().String()}, "secret"))
+	peer.JoinCluster(MakeCluster(peerHTTP.Listener.Addr().String(), []string{self}, "secret"))
+
+	body, _ := json.Marshal(models.ClientInfo{CName: "orders", CPort: 8080})
+	recorder := httptest.NewRecorder()
+	srv.registerHandler(recorder, httptest.NewRequest("POST", "/aidi/register", bytes.NewReader(body)))
+
+	deadline := time.Now().Add(time.Second)
+	for len(peerStore.Get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if clients := peerStore.Get(); len(clients) != 1 || clients[0].Name() != "orders" {
+		t.Errorf("wanted registration pushed to peer, got %+v", clients)
+	}
+}
+
+func TestPingAllConcurrent(t *testing.T) {
+	cs, ss := &memClientStore{}, &memStatusStore{}
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		json.NewEncoder(w).Encode(models.MakeHealthStatus())
+	}))
+	defer target.Close()
+	for _, name := range []string{"orders", "payments", "search", "users"} {
+		cs.Save(models.ClientInfo{CName: name, CURL: target.URL})
+	}
+	srv := MakeServer(cs, ss)
+
+	start := time.Now()
+	srv.pingAll()
+	if took := time.Since(start); took > 600*time.Millisecond {
+		t.Errorf("wanted clients polled at once, round took %v", took)
+	}
+	if statuses := ss.FindAll(); len(statuses) != 4 {
+		t.Errorf("wanted every client polled, got %+v", statuses)
+	}
+}
+
+// memClientStore and memStatusStore keep what is saved, as the store package cannot be
+// imported here.
+type memClientStore struct {
+	db          []models.ClientInfo
+	maintenance []models.Maintenance
+	mutex       sync.Mutex
+}
+
+func (mcs *memClientStore) Save(ci models.ClientInfo) {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+	for i := range mcs.db {
+		if mcs.db[i].ID() == ci.ID() {
+			mcs.db[i] = ci
+			return
+		}
+	}
+	mcs.db = append(mcs.db, ci)
+}
+
+func (mcs *memClientStore) Delete(id string) {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+	for i := range mcs.db {
+		if mcs.db[i].ID() == id {
+			mcs.db = append(mcs.db[:i], mcs.db[i+1:]...)
+			return
+		}
+	}
+}
+
+func (mcs *memClientStore) Get() []models.ClientInfo {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+	return append([]models.ClientInfo(nil), mcs.db...)
+}
+
+func (mcs *memClientStore) SaveMaintenance(m models.Maintenance) {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+	for i := range mcs.maintenance {
+		if mcs.maintenance[i].ID == m.ID {
+			mcs.maintenance[i] = m
+			return
+		}
+	}
+	mcs.maintenance = append(mcs.maintenance, m)
+}
+
+func (mcs *memClientStore) DeleteMaintenance(id string) bool {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+	for i := range mcs.maintenance {
+		if mcs.maintenance[i].ID == id {
+			mcs.maintenance = append(mcs.maintenance[:i], mcs.maintenance[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (mcs *memClientStore) Maintenance() []models.Maintenance {
+	mcs.mutex.Lock()
+	defer mcs.mutex.Unlock()
+	return append([]models.Maintenance(nil), mcs.maintenance...)
+}
+
+type memStatusStore struct {
+	db    map[string]HealthStatus
+	mutex sync.Mutex
+}
+
+func (mss *memStatusStore) Save(hs HealthStatus) {
+	mss.mutex.Lock()
+	defer mss.mutex.Unlock()
+	if mss.db == nil {
+		mss.db = make(map[string]HealthStatus)
+	}
+	mss.db[hs.ID()] = hs
+}
+
+func (mss *memStatusStore) SaveAll(hss ...HealthStatus) {
+	for _, hs := range hss {
+		mss.Save(hs)
+	}
+}
+
+func (mss *memStatusStore) Find(id string) (HealthStatus, error) {
+	mss.mutex.Lock()
+	defer mss.mutex.Unlock()
+	hs, ok := mss.db[id]
+	if !ok {
+		return HealthStatus{}, fmt.Errorf("%s not found", id)
+	}
+	return hs, nil
+}
+
+func (mss *memStatusStore) FindAll() []HealthStatus {
+	mss.mutex.Lock()
+	defer mss.mutex.Unlock()
+	ret := make([]HealthStatus, 0, len(mss.db))
+	for _, hs := range mss.db {
+		ret = append(ret, hs)
+	}
+	return ret
+}
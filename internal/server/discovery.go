@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// discoveryInterval is how often every discoverer is asked for its targets.
+const discoveryInterval = 15 * time.Second
+
+// DefaultHealthPath is where discovered targets are expected to serve their health status
+// when the source does not say.
+const DefaultHealthPath = "/metrics/health"
+
+// Discoverer finds scrape targets for services that do not register themselves. Name is
+// recorded as the Origin of every client it returns.
+type Discoverer interface {
+	Name() string
+	Discover(ctx context.Context) ([]models.ClientInfo, error)
+}
+
+// ClientDeleter can be implemented by a ClientStore to let the server drop discovered
+// clients, by ID, once their source no longer lists them. Without it they are kept.
+type ClientDeleter interface {
+	Delete(id string)
+}
+
+// AddDiscoverer has the server poll clients found by d alongside the registered ones. It
+// must be called before Start.
+func (srv *Server) AddDiscoverer(d Discoverer) {
+	srv.discoverers = append(srv.discoverers, d)
+}
+
+// discover runs every discoverer each interval, never returning.
+func (srv *Server) discover(interval time.Duration) {
+	for {
+		srv.discoverAll(context.Background())
+		time.Sleep(interval)
+	}
+}
+
+// discoverAll saves the clients found by every discoverer into the ClientStore. A
+// discovered client never replaces one that registered itself, and one its discoverer no
+// longer lists is deleted. A discoverer that fails keeps its clients until it recovers.
+func (srv *Server) discoverAll(ctx context.Context) {
+	for _, d := range srv.discoverers {
+		found, err := d.Discover(ctx)
+		if err != nil {
+			log.Printf("server: discovery from %s failed -- %v", d.Name(), err)
+			continue
+		}
+
+		existing := make(map[string]models.ClientInfo)
+		for _, info := range srv.clientStore.Get() {
+			existing[info.ID()] = info
+		}
+
+		listed := make(map[string]bool)
+		for _, info := range found {
+			if current, ok := existing[info.ID()]; ok && current.Origin != d.Name() && !current.Discovered() {
+				log.Printf("server: %s discovered %s which already registered, skipping", d.Name(), info.ID())
+				continue
+			}
+			info.Origin = d.Name()
+			listed[info.ID()] = true
+			srv.clientStore.Save(info)
+		}
+
+		deleter, ok := srv.clientStore.(ClientDeleter)
+		if !ok {
+			continue
+		}
+		for id, info := range existing {
+			if info.Origin == d.Name() && !listed[id] {
+				log.Printf("server: %s no longer lists %s, removing", d.Name(), id)
+				deleter.Delete(id)
+			}
+		}
+	}
+}
+
+// watchedFile caches the clients parsed from a file, only reading it again once its
+// modification time or size changes so edits are picked up on the next discovery run.
+type watchedFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	targets []models.ClientInfo
+	mutex   sync.Mutex
+}
+
+func (wf *watchedFile) load(parse func(data []byte) ([]models.ClientInfo, error)) ([]models.ClientInfo, error) {
+	wf.mutex.Lock()
+	defer wf.mutex.Unlock()
+
+	stat, err := os.Stat(wf.path)
+	if err != nil {
+		return nil, err
+	}
+	if wf.targets != nil && stat.ModTime().Equal(wf.modTime) && stat.Size() == wf.size {
+		return wf.targets, nil
+	}
+
+	data, err := ioutil.ReadFile(wf.path)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", wf.path, err)
+	}
+	log.Printf("server: read %d targets from %s", len(targets), wf.path)
+	wf.targets, wf.modTime, wf.size = targets, stat.ModTime(), stat.Size()
+	return targets, nil
+}
+
+// Target is an entry of a static targets file, either a url serving a HealthStatus or a
+// blackbox probe.
+type Target struct {
+	Name  string        `json:"name"`
+	URL   string        `json:"url,omitempty"`
+	Probe *models.Probe `json:"probe,omitempty"`
+}
+
+// FileDiscoverer reads targets from a json file holding a list of Target, picking up
+// changes to the file.
+type FileDiscoverer struct {
+	file watchedFile
+}
+
+// MakeFileDiscoverer provides a FileDiscoverer watching path.
+func MakeFileDiscoverer(path string) *FileDiscoverer {
+	return &FileDiscoverer{file: watchedFile{path: path}}
+}
+
+// Name identifies the discoverer as the Origin of its clients.
+func (fd *FileDiscoverer) Name() string {
+	return "file:" + fd.file.path
+}
+
+// Discover returns the targets in the file.
+func (fd *FileDiscoverer) Discover(ctx context.Context) ([]models.ClientInfo, error) {
+	return fd.file.load(func(data []byte) ([]models.ClientInfo, error) {
+		targets := []Target{}
+		if err := json.Unmarshal(data, &targets); err != nil {
+			return nil, err
+		}
+		infos := make([]models.ClientInfo, 0, len(targets))
+		for _, target := range targets {
+			if target.Name == "" || (target.URL == "") == (target.Probe == nil) {
+				return nil, fmt.Errorf("target needs a name and either a url or probe, got %+v", target)
+			}
+			if target.Probe != nil {
+				if err := target.Probe.Validate(); err != nil {
+					return nil, fmt.Errorf("target %s: %v", target.Name, err)
+				}
+			}
+			infos = append(infos, models.ClientInfo{CName: target.Name, CURL: target.URL, Probe: target.Probe})
+		}
+		return infos, nil
+	})
+}
+
+// SRVDiscoverer finds targets from the DNS SRV records of a service, one instance of the
+// service per record, identified as "host:port" after the record's target.
+type SRVDiscoverer struct {
+	service string
+	proto   string
+	domain  string
+	path    string
+	lookup  func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// MakeSRVDiscoverer provides an SRVDiscoverer looking up _service._proto.domain. Targets are
+// polled on path, DefaultHealthPath when empty.
+func MakeSRVDiscoverer(service, proto, domain, path string) *SRVDiscoverer {
+	if path == "" {
+		path = DefaultHealthPath
+	}
+	return &SRVDiscoverer{
+		service: service,
+		proto:   proto,
+		domain:  domain,
+		path:    path,
+		lookup:  net.DefaultResolver.LookupSRV,
+	}
+}
+
+// Name identifies the discoverer as the Origin of its clients.
+func (sd *SRVDiscoverer) Name() string {
+	return fmt.Sprintf("srv:_%s._%s.%s", sd.service, sd.proto, sd.domain)
+}
+
+// Discover resolves the SRV records.
+func (sd *SRVDiscoverer) Discover(ctx context.Context) ([]models.ClientInfo, error) {
+	_, records, err := sd.lookup(ctx, sd.service, sd.proto, sd.domain)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]models.ClientInfo, 0, len(records))
+	for _, record := range records {
+		addr := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		infos = append(infos, models.ClientInfo{
+			CName:    sd.service,
+			Instance: addr,
+			CPort:    int(record.Port),
+			CURL:     "http://" + addr + sd.path,
+		})
+	}
+	return infos, nil
+}
+
+// CatalogInstance is one instance of a service in a catalog file.
+type CatalogInstance struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Path    string `json:"path,omitempty"`
+}
+
+// CatalogDiscoverer reads a service catalog, a json object of service name to its
+// instances, as exported from a registry such as consul, picking up changes to the file.
+// Each instance is identified as "host:port".
+type CatalogDiscoverer struct {
+	file watchedFile
+}
+
+// MakeCatalogDiscoverer provides a CatalogDiscoverer watching path.
+func MakeCatalogDiscoverer(path string) *CatalogDiscoverer {
+	return &CatalogDiscoverer{file: watchedFile{path: path}}
+}
+
+// Name identifies the discoverer as the Origin of its clients.
+func (cd *CatalogDiscoverer) Name() string {
+	return "catalog:" + cd.file.path
+}
+
+// Discover returns every instance in the catalog.
+func (cd *CatalogDiscoverer) Discover(ctx context.Context) ([]models.ClientInfo, error) {
+	return cd.file.load(parseCatalog)
+}
+
+func parseCatalog(data []byte) ([]models.ClientInfo, error) {
+	catalog := map[string][]CatalogInstance{}
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, err
+	}
+
+	services := make([]string, 0, len(catalog))
+	for service := range catalog {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	infos := make([]models.ClientInfo, 0)
+	for _, service := range services {
+		for _, instance := range catalog[service] {
+			if instance.Address == "" || instance.Port == 0 {
+				return nil, fmt.Errorf("instance of %s needs an address and port", service)
+			}
+			path := instance.Path
+			if path == "" {
+				path = DefaultHealthPath
+			}
+			addr := net.JoinHostPort(instance.Address, strconv.Itoa(instance.Port))
+			infos = append(infos, models.ClientInfo{CName: service, Instance: addr, CPort: instance.Port, CURL: "http://" + addr + path})
+		}
+	}
+	return infos, nil
+}
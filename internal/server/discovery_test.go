@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func writeFile(t *testing.T, path, data string, modTime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func names(infos []models.ClientInfo) []string {
+	ret := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ret = append(ret, info.Name())
+	}
+	return ret
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "targets.json")
+	writeFile(t, path, `[{"name": "legacy", "url": "http://10.0.0.1:8080/health"}]`, time.Unix(1000, 0))
+
+	fd := MakeFileDiscoverer(path)
+	infos, err := fd.Discover(context.Background())
+	if err != nil || len(infos) != 1 || infos[0].URL() != "http://10.0.0.1:8080/health" {
+		t.Fatalf("wanted legacy target, got %+v %v", infos, err)
+	}
+
+	writeFile(t, path, `[{"name": "legacy", "url": "http://10.0.0.1:8080/health"}, {"name": "batch", "url": "http://10.0.0.2/health"}]`, time.Unix(2000, 0))
+	infos, err = fd.Discover(context.Background())
+	if err != nil || len(infos) != 2 {
+		t.Errorf("wanted change picked up, got %v %v", names(infos), err)
+	}
+
+	writeFile(t, path, `[{"name": "nourl"}]`, time.Unix(3000, 0))
+	if _, err := fd.Discover(context.Background()); err == nil {
+		t.Error("wanted error for target without url")
+	}
+}
+
+func TestCatalogDiscoverer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "catalog.json")
+	writeFile(t, path, `{
+		"orders": [{"address": "10.0.0.1", "port": 8080}, {"address": "10.0.0.2", "port": 8080}],
+		"users": [{"address": "fd00::1", "port": 9000, "path": "/status"}]
+	}`, time.Unix(1000, 0))
+
+	infos, err := MakeCatalogDiscoverer(path).Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []models.ClientInfo{
+		{CName: "orders", Instance: "10.0.0.1:8080", CPort: 8080, CURL: "http://10.0.0.1:8080/metrics/health"},
+		{CName: "orders", Instance: "10.0.0.2:8080", CPort: 8080, CURL: "http://10.0.0.2:8080/metrics/health"},
+		{CName: "users", Instance: "[fd00::1]:9000", CPort: 9000, CURL: "http://[fd00::1]:9000/status"},
+	}
+	if len(infos) != len(expect) {
+		t.Fatalf("wanted %v, got %v", expect, infos)
+	}
+	for i := range expect {
+		if !reflect.DeepEqual(infos[i], expect[i]) {
+			t.Errorf("wanted %+v, got %+v", expect[i], infos[i])
+		}
+	}
+}
+
+func TestSRVDiscoverer(t *testing.T) {
+	sd := MakeSRVDiscoverer("orders", "tcp", "example.com", "")
+	sd.lookup = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if service != "orders" || proto != "tcp" || name != "example.com" {
+			return "", nil, errors.New("no such record")
+		}
+		return "", []*net.SRV{{Target: "a.example.com.", Port: 8080}, {Target: "b.example.com.", Port: 8081}}, nil
+	}
+
+	infos, err := sd.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].ID() != "orders@a.example.com:8080" || infos[1].URL() != "http://b.example.com:8081/metrics/health" {
+		t.Errorf("wanted both records as targets, got %+v", infos)
+	}
+	if sd.Name() != "srv:_orders._tcp.example.com" {
+		t.Errorf("unexpected name %s", sd.Name())
+	}
+}
+
+// listDiscoverer returns whatever it is set to.
+type listDiscoverer struct {
+	infos []models.ClientInfo
+	err   error
+}
+
+func (ld *listDiscoverer) Name() string { return "list" }
+func (ld *listDiscoverer) Discover(ctx context.Context) ([]models.ClientInfo, error) {
+	return ld.infos, ld.err
+}
+
+func TestDiscoverAll(t *testing.T) {
+	cs := &memClientStore{}
+	cs.Save(models.ClientInfo{CName: "orders", CURL: "http://registered", Origin: models.OriginRegistered})
+	srv := MakeServer(cs, &memStatusStore{})
+	ld := &listDiscoverer{infos: []models.ClientInfo{
+		{CName: "orders", CURL: "http://discovered"},
+		{CName: "legacy", CURL: "http://legacy"},
+		{CName: "batch", CURL: "http://batch"},
+	}}
+	srv.AddDiscoverer(ld)
+	srv.discoverAll(context.Background())
+
+	clients := map[string]models.ClientInfo{}
+	for _, info := range cs.Get() {
+		clients[info.Name()] = info
+	}
+	if clients["orders"].URL() != "http://registered" || clients["orders"].Discovered() {
+		t.Errorf("wanted registered client kept, got %+v", clients["orders"])
+	}
+	if !clients["legacy"].Discovered() || clients["legacy"].Origin != "list" {
+		t.Errorf("wanted legacy marked discovered, got %+v", clients["legacy"])
+	}
+
+	// a failing source keeps its clients
+	ld.err = errors.New("unavailable")
+	srv.discoverAll(context.Background())
+	if len(cs.Get()) != 3 {
+		t.Errorf("wanted clients kept while discovery fails, got %v", names(cs.Get()))
+	}
+
+	// batch is no longer listed
+	ld.infos, ld.err = ld.infos[:2], nil
+	srv.discoverAll(context.Background())
+	if got := names(cs.Get()); len(got) != 2 || got[0] != "orders" || got[1] != "legacy" {
+		t.Errorf("wanted batch removed, got %v", got)
+	}
+}
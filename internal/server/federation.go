@@ -0,0 +1,294 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// federationInterval is how often every upstream is pulled.
+const federationInterval = 5 * time.Second
+
+// staleAfter is how old an upstream's data can get before it is reported stale.
+const staleAfter = 3 * federationInterval
+
+// LocalSource is the source name given to this server's own clients in the merged view.
+const LocalSource = "local"
+
+// Upstream is a child aidi server whose clients are pulled into the merged view.
+type Upstream struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// SourceStatus describes how fresh the data from one source is. Age is in seconds since the
+// last successful pull; Error is the last failure, cleared by the next success.
+type SourceStatus struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url,omitempty"`
+	Fetched time.Time `json:"fetched"`
+	Age     float64   `json:"age"`
+	Stale   bool      `json:"stale"`
+	Clients int       `json:"clients"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// FederatedStatus is the merged view of this server and its upstreams. Client names are
+// prefixed with their source, as "source/client". An upstream that federates servers of its
+// own passes on its merged view, so their clients and sources are nested under it, as
+// "source/child/client".
+type FederatedStatus struct {
+	Sources []SourceStatus `json:"sources"`
+	Clients []HealthStatus `json:"clients"`
+}
+
+// upstream is an Upstream with the merged view of its last pull.
+type upstream struct {
+	Upstream
+	merged  FederatedStatus
+	fetched time.Time
+	err     error
+}
+
+// Federation pulls the clients of child aidi servers so one parent can serve a global view.
+type Federation struct {
+	upstreams map[string]*upstream
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+// MakeFederation provides an empty Federation.
+func MakeFederation() *Federation {
+	return &Federation{
+		upstreams: make(map[string]*upstream),
+		now:       time.Now,
+	}
+}
+
+// Add registers a child server, replacing any upstream with the same name. Names are used
+// to prefix client names so they may not contain a "/" or be LocalSource.
+func (f *Federation) Add(up Upstream) error {
+	if up.Name == "" || up.Name == LocalSource || strings.Contains(up.Name, "/") {
+		return fmt.Errorf("invalid upstream name %q", up.Name)
+	}
+	if !strings.HasPrefix(up.URL, "http://") && !strings.HasPrefix(up.URL, "https://") {
+		return fmt.Errorf("invalid upstream url %q", up.URL)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.upstreams[up.Name] = &upstream{Upstream: Upstream{Name: up.Name, URL: strings.TrimSuffix(up.URL, "/")}}
+	return nil
+}
+
+// Remove drops a child server and its clients from the merged view.
+func (f *Federation) Remove(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.upstreams, name)
+}
+
+// Run pulls every upstream each interval, never returning.
+func (f *Federation) Run(interval time.Duration) {
+	for {
+		f.PullAll()
+		time.Sleep(interval)
+	}
+}
+
+// PullAll fetches the clients of every upstream at once. An upstream that fails keeps its
+// last statuses, which go stale.
+func (f *Federation) PullAll() {
+	f.mutex.Lock()
+	ups := make([]Upstream, 0, len(f.upstreams))
+	for _, up := range f.upstreams {
+		ups = append(ups, up.Upstream)
+	}
+	f.mutex.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, up := range ups {
+		wg.Add(1)
+		go func(up Upstream) {
+			defer wg.Done()
+			merged, err := pull(up.URL)
+			if err != nil {
+				log.Printf("federation: could not pull %s -- %v", up.Name, err)
+			}
+
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			current, ok := f.upstreams[up.Name]
+			if !ok || current.URL != up.URL {
+				// removed or replaced while pulling
+				return
+			}
+			current.err = err
+			if err == nil {
+				current.merged, current.fetched = merged, f.now()
+			}
+		}(up)
+	}
+	wg.Wait()
+}
+
+// pull fetches the merged view of the upstream at url. Servers without federation only
+// serve their own clients, which are taken as the upstream's local source.
+func pull(url string) (FederatedStatus, error) {
+	merged := FederatedStatus{}
+	err := fetchJSON(url+"/aidi/federation", &merged)
+	if err != errNotFound {
+		return merged, err
+	}
+
+	statuses := []HealthStatus{}
+	if err := fetchJSON(url+"/aidi/health/", &statuses); err != nil {
+		return FederatedStatus{}, err
+	}
+	return FederatedStatus{
+		Sources: []SourceStatus{{Name: LocalSource, Clients: len(statuses)}},
+		Clients: namespace(LocalSource, statuses),
+	}, nil
+}
+
+var errNotFound = errors.New("did not get 200 response, got 404")
+
+func fetchJSON(url string, v interface{}) error {
+	resp, err := httpcli.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("did not get 200 response, got %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Merge builds the merged view of local, this server's own clients, and every upstream.
+// The sources an upstream federates are listed under it as "upstream/source", stale when
+// either hop is. Sources and clients are sorted by name.
+func (f *Federation) Merge(local []HealthStatus) FederatedStatus {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	now := f.now()
+
+	ret := FederatedStatus{
+		Sources: []SourceStatus{{Name: LocalSource, Fetched: now, Clients: len(local)}},
+		Clients: namespace(LocalSource, local),
+	}
+	for _, up := range f.upstreams {
+		source := SourceStatus{
+			Name:    up.Name,
+			URL:     up.URL,
+			Fetched: up.fetched,
+			Stale:   up.fetched.IsZero() || now.Sub(up.fetched) > staleAfter,
+			Clients: len(up.merged.Clients),
+		}
+		if !up.fetched.IsZero() {
+			source.Age = now.Sub(up.fetched).Seconds()
+		}
+		if up.err != nil {
+			source.Error = up.err.Error()
+		}
+		ret.Sources = append(ret.Sources, source)
+		for _, nested := range up.merged.Sources {
+			if nested.Name == LocalSource {
+				continue
+			}
+			nested.Name = up.Name + "/" + nested.Name
+			nested.Age += source.Age
+			nested.Stale = nested.Stale || source.Stale
+			ret.Sources = append(ret.Sources, nested)
+		}
+		ret.Clients = append(ret.Clients, namespace(up.Name, up.merged.Clients)...)
+	}
+
+	sort.Slice(ret.Sources, func(i, j int) bool { return ret.Sources[i].Name < ret.Sources[j].Name })
+	sort.Slice(ret.Clients, func(i, j int) bool { return ret.Clients[i].ClientName < ret.Clients[j].ClientName })
+	return ret
+}
+
+func namespace(source string, statuses []HealthStatus) []HealthStatus {
+	ret := make([]HealthStatus, 0, len(statuses))
+	for _, hs := range statuses {
+		hs.ClientName = source + "/" + hs.ClientName
+		ret = append(ret, hs)
+	}
+	return ret
+}
+
+// federationHandler serves the merged view on GET, optionally narrowed to one source with
+// ?source= and by labels with ?selector=; a source includes the sources nested under it.
+// POST registers an upstream and DELETE with ?name= removes one, both only with the server's
+// auth token.
+func (srv *Server) federationHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		sel, ok := selector(w, r)
+		if !ok {
+			return
+		}
+		merged := srv.federation.Merge(srv.statusStore.FindAll())
+		if source := r.URL.Query().Get("source"); source != "" {
+			merged = filterSource(merged, source)
+		}
+		merged.Clients = filterStatuses(merged.Clients, sel)
+		if err := json.NewEncoder(w).Encode(&merged); err != nil {
+			log.Printf("server: encountered error encoding json: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+	case "POST":
+		if !srv.requireAuth(w, r) {
+			return
+		}
+		up := Upstream{}
+		err := json.NewDecoder(r.Body).Decode(&up)
+		if err == nil {
+			err = srv.federation.Add(up)
+		}
+		if err != nil {
+			log.Printf("server: bad upstream recieved %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		go srv.federation.PullAll()
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		if !srv.requireAuth(w, r) {
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		srv.federation.Remove(name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func filterSource(merged FederatedStatus, source string) FederatedStatus {
+	ret := FederatedStatus{Sources: []SourceStatus{}, Clients: []HealthStatus{}}
+	for _, s := range merged.Sources {
+		if s.Name == source || strings.HasPrefix(s.Name, source+"/") {
+			ret.Sources = append(ret.Sources, s)
+		}
+	}
+	for _, hs := range merged.Clients {
+		if strings.HasPrefix(hs.ClientName, source+"/") {
+			ret.Clients = append(ret.Clients, hs)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// childServer is an upstream without federation, serving only its own clients.
+func childServer(statuses ...HealthStatus) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/aidi/health/" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(statuses)
+	}))
+}
+
+func TestFederationMerge(t *testing.T) {
+	east := childServer(HealthStatus{ClientName: "orders", Updated: 1})
+	defer east.Close()
+	west := childServer(HealthStatus{ClientName: "orders", Updated: 2}, HealthStatus{ClientName: "users", Updated: 2})
+
+	now := time.Unix(1000, 0)
+	f := MakeFederation()
+	f.now = func() time.Time { return now }
+	for _, up := range []Upstream{{"east", east.URL}, {"west", west.URL + "/"}} {
+		if err := f.Add(up); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	f.PullAll()
+
+	// west goes down and its data ages
+	west.Close()
+	now = now.Add(staleAfter + time.Second)
+	f.PullAll()
+
+	merged := f.Merge([]HealthStatus{{ClientName: "aidi"}})
+	names := []string{}
+	for _, hs := range merged.Clients {
+		names = append(names, hs.ClientName)
+	}
+	expect := []string{"east/local/orders", "local/aidi", "west/local/orders", "west/local/users"}
+	if len(names) != len(expect) {
+		t.Fatalf("wanted %v, got %v", expect, names)
+	}
+	for i := range expect {
+		if names[i] != expect[i] {
+			t.Errorf("wanted %v, got %v", expect, names)
+			break
+		}
+	}
+
+	var testCases = []struct {
+		source  string
+		stale   bool
+		failed  bool
+		clients int
+	}{
+		{"east", false, false, 1},
+		{"local", false, false, 1},
+		{"west", true, true, 2},
+	}
+	if len(merged.Sources) != len(testCases) {
+		t.Fatalf("wanted %d sources, got %+v", len(testCases), merged.Sources)
+	}
+	for i, test := range testCases {
+		source := merged.Sources[i]
+		if source.Name != test.source || source.Stale != test.stale || (source.Error != "") != test.failed || source.Clients != test.clients {
+			t.Errorf("%s: wanted stale %t failed %t with %d clients, got %+v", test.source, test.stale, test.failed, test.clients, source)
+		}
+	}
+}
+
+func TestFederationNested(t *testing.T) {
+	dc1 := childServer(HealthStatus{ClientName: "orders"})
+	defer dc1.Close()
+	eu := MakeServer(&memClientStore{}, &memStatusStore{})
+	eu.statusStore.Save(HealthStatus{ClientName: "aidi"})
+	if err := eu.AddUpstream(Upstream{Name: "dc1", URL: dc1.URL}); err != nil {
+		t.Fatal(err)
+	}
+	eu.federation.PullAll()
+	euHTTP := httptest.NewServer(http.HandlerFunc(eu.federationHandler))
+	defer euHTTP.Close()
+
+	f := MakeFederation()
+	if err := f.Add(Upstream{Name: "eu", URL: euHTTP.URL}); err != nil {
+		t.Fatal(err)
+	}
+	f.PullAll()
+	merged := f.Merge(nil)
+
+	names := []string{}
+	for _, hs := range merged.Clients {
+		names = append(names, hs.ClientName)
+	}
+	if strings.Join(names, ",") != "eu/dc1/local/orders,eu/local/aidi" {
+		t.Errorf("wanted clients of eu and dc1 nested under eu, got %v", names)
+	}
+	sources := []string{}
+	for _, source := range merged.Sources {
+		sources = append(sources, source.Name)
+	}
+	if strings.Join(sources, ",") != "eu,eu/dc1,local" || merged.Sources[0].Clients != 2 {
+		t.Errorf("wanted dc1 listed under eu, got %+v", merged.Sources)
+	}
+	if only := filterSource(merged, "eu"); len(only.Sources) != 2 || len(only.Clients) != 2 {
+		t.Errorf("wanted eu to include dc1, got %+v", only)
+	}
+}
+
+func TestFederationAddInvalid(t *testing.T) {
+	f := MakeFederation()
+	for _, up := range []Upstream{{"", "http://a"}, {"local", "http://a"}, {"a/b", "http://a"}, {"east", "a:9900"}} {
+		if err := f.Add(up); err == nil {
+			t.Errorf("%+v: wanted error", up)
+		}
+	}
+}
+
+func TestFederationHandler(t *testing.T) {
+	child := childServer(HealthStatus{ClientName: "orders"})
+	defer child.Close()
+	srv := MakeServer(&memClientStore{}, &memStatusStore{})
+	srv.SetAuthToken("secret")
+
+	body, _ := json.Marshal(Upstream{Name: "east", URL: child.URL})
+	for _, auth := range []string{"", "Bearer wrong"} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/aidi/federation", bytes.NewReader(body))
+		request.Header.Set("Authorization", auth)
+		srv.federationHandler(recorder, request)
+		if recorder.Code != http.StatusUnauthorized || len(srv.federation.Merge(nil).Sources) != 1 {
+			t.Errorf("%q: wanted 401, got %d", auth, recorder.Code)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/aidi/federation", bytes.NewReader(body))
+	request.Header.Set("Authorization", "Bearer secret")
+	srv.federationHandler(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("wanted 201, got %d", recorder.Code)
+	}
+	srv.federation.PullAll()
+
+	recorder = httptest.NewRecorder()
+	srv.federationHandler(recorder, httptest.NewRequest("GET", "/aidi/federation?source=east", nil))
+	merged := FederatedStatus{}
+	if err := json.NewDecoder(recorder.Body).Decode(&merged); err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Sources) != 1 || len(merged.Clients) != 1 || merged.Clients[0].ClientName != "east/local/orders" {
+		t.Errorf("wanted only east/local/orders, got %+v", merged)
+	}
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("DELETE", "/aidi/federation?name=east", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	srv.federationHandler(recorder, request)
+	if merged := srv.federation.Merge(nil); recorder.Code != http.StatusNoContent || len(merged.Sources) != 1 {
+		t.Errorf("wanted east removed, got %d %+v", recorder.Code, merged.Sources)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -12,54 +13,82 @@ import (
 	"github.com/markpotocki/health/pkg/models"
 )
 
+// registerHandler is the original registration endpoint, kept for clients that predate
+// /aidi/v1/register. It takes a ClientInfo and answers in plain text; a missing port still
+// defaults to 9999.
 func (srv *Server) registerHandler(w http.ResponseWriter, r *http.Request) {
 	clientInfo := models.ClientInfo{}
 
 	err := json.NewDecoder(r.Body).Decode(&clientInfo)
-
-	if clientInfo.CPort == 0 {
-		clientInfo.CPort = 9999 // for backwards compatability
-	}
-
 	if err != nil {
 		log.Printf("server-register: bad type recieved %v", err)
 		http.Error(w, "not expected json", http.StatusBadRequest)
+		return
 	}
 
-	clientAddr := r.RemoteAddr
-	splited := strings.Split(clientAddr, ":")
-	if baseurl := splited[0]; baseurl != "" {
-		clientInfo.CURL = fmt.Sprintf("http://%s:%d/metrics/health", baseurl, clientInfo.CPort)
+	req := models.RegisterRequest{
+		Name:     clientInfo.CName,
+		Instance: clientInfo.Instance,
+		Port:     clientInfo.CPort,
+		URL:      clientInfo.CURL,
+		Labels:   clientInfo.Labels,
+		Metadata: clientInfo.Metadata,
+		Probe:    clientInfo.Probe,
+	}
+	if req.Port == 0 && req.URL == "" && req.Probe == nil {
+		log.Printf("server-register: %s sent no port, assuming 9999", req.Name)
+		req.Port = 9999 // for backwards compatability
+	}
+	if req.Probe != nil && !srv.requireAuth(w, r) {
+		log.Printf("server-register: refused probe of %s without the auth token", req.Name)
+		return
+	}
+	if advertisedElsewhere(req, r.RemoteAddr) && !srv.requireAuth(w, r) {
+		log.Printf("server-register: refused %s advertised at %s from %s without the auth token", req.Name, req.URL, r.RemoteAddr)
+		return
+	}
+	if errs := legacyErrors(req); len(errs) > 0 {
+		log.Printf("server-register: invalid registration recieved %v", errs)
+		http.Error(w, fmt.Sprintf("%s %s", errs[0].Field, errs[0].Message), http.StatusBadRequest)
+		return
 	}
 
-	srv.clientStore.Save(clientInfo)
-
+	info := clientFromRequest(req, r.RemoteAddr)
+	info.Key = clientInfo.Key
+	srv.saveClient(info)
 	w.WriteHeader(http.StatusCreated)
-
 }
 
-func (srv *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK) // can add checks for whatever here
+// legacyErrors validates a registration from the legacy endpoint. Names the versioned api
+// would reject are accepted with a warning, so existing clients keep working, unless they
+// are empty or hold a character that would break client IDs.
+func legacyErrors(req models.RegisterRequest) []models.FieldError {
+	errs := []models.FieldError{}
+	for _, err := range req.Validate() {
+		if err.Field == "name" && req.Name != "" && !strings.ContainsAny(req.Name, "@/") {
+			log.Printf("server-register: name %q is deprecated, /aidi/v1/register does not accept it", req.Name)
+			continue
+		}
+		errs = append(errs, err)
+	}
+	return errs
 }
 
 func (srv *Server) clientInfoHandler(w http.ResponseWriter, r *http.Request) {
-
-	// start with http://localhost:0/info/param
-	httpTrim := strings.TrimPrefix(r.RequestURI, "http://")
-	httpTrim = strings.TrimPrefix(r.RequestURI, "https://")
-	httpTrim = strings.TrimPrefix(httpTrim, "/")
+	// route on the path alone, the query holds the selector
+	httpTrim := strings.TrimPrefix(r.URL.Path, "/")
 	httpTrim = strings.TrimSuffix(httpTrim, "/")
 
-	// localhost:0/aidi/info/param/
+	// aidi/info/param
 	split := strings.Split(httpTrim, "/")
 	if len(split) > 3 {
 		log.Println("server: invalid path in info handler")
 		http.Error(w, "Not Found", http.StatusNotFound)
 	} else if len(split) == 3 {
 
-		info := srv.statusStore.Find(split[2])
+		info, err := srv.statusStore.Find(split[2])
 		log.Printf("found client %v", info)
-		if info.ClientName == "" {
+		if err != nil {
 			http.Error(w, "could not find the requested client", http.StatusNotFound)
 		} else {
 			err := json.NewEncoder(w).Encode(&info)
@@ -69,29 +98,49 @@ func (srv *Server) clientInfoHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	} else {
-		if shouldPoll {
-			longPoll(srv)
-		}
-		info := srv.statusStore.FindAll()
+		srv.allClientInfoHandler(w, r)
+	}
+}
 
-		err := json.NewEncoder(w).Encode(&info)
-		if err != nil {
-			log.Printf("server: encountered error decoding json: %v", err)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+// allClientInfoHandler lists the status of every client, or those matching ?selector=. With
+// ?poll=true the response is held until the next round of pings has been saved.
+func (srv *Server) allClientInfoHandler(w http.ResponseWriter, r *http.Request) {
+	sel, ok := selector(w, r)
+	if !ok {
+		return
+	}
+	if shouldPoll := r.URL.Query().Get("poll") == "true"; shouldPoll {
+		if err := longPoll(r.Context(), srv); err != nil {
+			return
 		}
 	}
+	info := srv.selectStatuses(sel)
+
+	err := json.NewEncoder(w).Encode(&info)
+	if err != nil {
+		log.Printf("server: encountered error decoding json: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
 }
 
-func longPoll(srv *Server) {
-	newDataChan := make(chan struct{})
+// longPoll waits for the next round of pings to be saved, or for ctx to end when the caller
+// has gone away.
+func longPoll(ctx context.Context, srv *Server) error {
+	// buffered so a round that finishes as the caller leaves does not block on it
+	newDataChan := make(chan struct{}, 1)
 	log.Println("long-polling: registering signal channel")
 	conID := getConnectionId()
 	srv.connections.Store(conID, newDataChan)
+	defer srv.connections.Delete(conID)
 	log.Println("long-polling: added connected")
-	log.Printf("long-polling: current connections -- %v", &srv.connections)
-	<-newDataChan
-	srv.connections.Delete(conID)
-	log.Println("long-polling: recieved new data")
+	select {
+	case <-newDataChan:
+		log.Println("long-polling: recieved new data")
+		return nil
+	case <-ctx.Done():
+		log.Println("long-polling: caller went away")
+		return ctx.Err()
+	}
 }
 
 func getConnectionId() int64 {
@@ -2,11 +2,14 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/markpotocki/health/pkg/models"
 )
@@ -146,26 +149,117 @@ func rhbadrequest(t *testing.T) {
 	assert(t, resp.StatusCode, 400)
 }
 
+// Ready Handler
+// Responses:
+// 	200 - stores reachable, poll loop running and dependencies up
+// 	503 - any of the above failing, with the failing check in the body
 func TestReadyHandler(t *testing.T) {
 	t.Run("success", readysuccess)
+	t.Run("store-down", readystoredown)
+	t.Run("no-heartbeat", readynoheartbeat)
+	t.Run("dependency-down", readydependencydown)
+	t.Run("dependency-panics", readydependencypanics)
 }
 
-func readysuccess(t *testing.T) {
-	// setup
-	srv := Server{
-		clientStore: &mockClientStore{},
-		statusStore: &mockStatusStore{},
-	}
-
+func readyResponse(t *testing.T, srv *Server) (int, ReadyResponse) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/ready", nil)
 
-	handler := http.HandlerFunc(srv.registerHandler)
+	handler := http.HandlerFunc(srv.readyHandler)
 	handler.ServeHTTP(recorder, request)
 
 	resp := recorder.Result()
+	defer resp.Body.Close()
 
-	assert(t, resp.StatusCode, 200)
+	ready := ReadyResponse{}
+	err := json.NewDecoder(resp.Body).Decode(&ready)
+	check(err)
+	return resp.StatusCode, ready
+}
+
+func failedChecks(ready ReadyResponse) []string {
+	failed := []string{}
+	for _, result := range ready.Checks {
+		if result.Status == models.CheckFail {
+			failed = append(failed, result.Name)
+		}
+	}
+	return failed
+}
+
+func readysuccess(t *testing.T) {
+	// setup
+	srv := MakeServer(&mockClientStore{}, &mockStatusStore{})
+	srv.beat()
+
+	code, ready := readyResponse(t, srv)
+
+	// check
+	if code != 200 || !ready.Ready || len(ready.Checks) != 3 {
+		t.Errorf("wanted ready with 3 checks, got %d %+v", code, ready)
+	}
+}
+
+func readystoredown(t *testing.T) {
+	// setup
+	srv := MakeServer(&mockClientStore{}, &mockPingStatusStore{err: errors.New("connection refused")})
+	srv.beat()
+
+	code, ready := readyResponse(t, srv)
+
+	// check
+	failed := failedChecks(ready)
+	if code != 503 || ready.Ready || len(failed) != 1 || failed[0] != "status-store" {
+		t.Errorf("wanted status-store to fail, got %d %+v", code, ready)
+	}
+}
+
+func readynoheartbeat(t *testing.T) {
+	// setup
+	srv := MakeServer(&mockClientStore{}, &mockStatusStore{})
+	srv.heartbeat = time.Now().Add(-time.Minute).UnixNano()
+
+	code, ready := readyResponse(t, srv)
+
+	// check
+	failed := failedChecks(ready)
+	if code != 503 || len(failed) != 1 || failed[0] != "poll-loop" {
+		t.Errorf("wanted poll-loop to fail, got %d %+v", code, ready)
+	}
+}
+
+func readydependencydown(t *testing.T) {
+	// setup
+	srv := MakeServer(&mockClientStore{}, &mockStatusStore{})
+	srv.beat()
+	srv.AddReadinessCheck("database", func(ctx context.Context) error {
+		return errors.New("unreachable")
+	})
+
+	code, ready := readyResponse(t, srv)
+
+	// check
+	failed := failedChecks(ready)
+	if code != 503 || len(failed) != 1 || failed[0] != "database" {
+		t.Errorf("wanted database to fail, got %d %+v", code, ready)
+	}
+}
+
+func readydependencypanics(t *testing.T) {
+	// setup
+	srv := MakeServer(&mockClientStore{}, &mockStatusStore{})
+	srv.beat()
+	srv.AddReadinessCheck("cache", func(ctx context.Context) error {
+		panic("cache client not configured")
+	})
+
+	code, ready := readyResponse(t, srv)
+
+	// check
+	failed := failedChecks(ready)
+	if code != 503 || len(failed) != 1 || failed[0] != "cache" {
+		t.Errorf("wanted cache to fail, got %d %+v", code, ready)
+	}
 }
 
 // Utils
@@ -178,7 +272,7 @@ func check(err error) {
 }
 
 func assert(t *testing.T, actual interface{}, expect interface{}) {
-	if actual != expect {
+	if !reflect.DeepEqual(actual, expect) {
 		t.Logf("assert: actual[%v] did not match expected[%v]", actual, expect)
 	}
 }
@@ -237,3 +331,12 @@ func (mss *mockStatusStore) FindAll() []HealthStatus {
 	foo, _ := mss.Find("test")
 	return []HealthStatus{foo}
 }
+
+type mockPingStatusStore struct {
+	mockStatusStore
+	err error
+}
+
+func (mss *mockPingStatusStore) Ping(ctx context.Context) error {
+	return mss.err
+}
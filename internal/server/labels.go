@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// ClientSelector can be implemented by a ClientStore to answer selector queries itself, ie
+// from an index. Without it every client is read and filtered.
+type ClientSelector interface {
+	Select(sel models.Selector) []models.ClientInfo
+}
+
+// StatusSelector can be implemented by a StatusStore to answer selector queries itself.
+// Without it every status is read and filtered.
+type StatusSelector interface {
+	FindSelected(sel models.Selector) []HealthStatus
+}
+
+// selector reads the ?selector= query, answering 400 when it does not parse.
+func selector(w http.ResponseWriter, r *http.Request) (models.Selector, bool) {
+	sel, err := models.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return sel, true
+}
+
+func (srv *Server) selectClients(sel models.Selector) []models.ClientInfo {
+	if cs, ok := srv.clientStore.(ClientSelector); ok {
+		return cs.Select(sel)
+	}
+	ret := make([]models.ClientInfo, 0)
+	for _, info := range srv.clientStore.Get() {
+		if sel.Matches(info.Labels) {
+			ret = append(ret, info)
+		}
+	}
+	return ret
+}
+
+func (srv *Server) selectStatuses(sel models.Selector) []HealthStatus {
+	if ss, ok := srv.statusStore.(StatusSelector); ok {
+		return ss.FindSelected(sel)
+	}
+	return filterStatuses(srv.statusStore.FindAll(), sel)
+}
+
+func filterStatuses(statuses []HealthStatus, sel models.Selector) []HealthStatus {
+	ret := make([]HealthStatus, 0, len(statuses))
+	for _, hs := range statuses {
+		if sel.Matches(hs.Labels) {
+			ret = append(ret, hs)
+		}
+	}
+	return ret
+}
+
+// clientsHandler lists the registered and discovered clients, with their labels and
+// metadata, narrowed by ?selector= when given.
+func (srv *Server) clientsHandler(w http.ResponseWriter, r *http.Request) {
+	sel, ok := selector(w, r)
+	if !ok {
+		return
+	}
+	clients := srv.selectClients(sel)
+	if err := json.NewEncoder(w).Encode(&clients); err != nil {
+		log.Printf("server: encountered error encoding json: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
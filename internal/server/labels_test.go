@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestSelectorQueries(t *testing.T) {
+	cs, ss := &memClientStore{}, &memStatusStore{}
+	srv := MakeServer(cs, ss)
+	for name, labels := range map[string]map[string]string{
+		"orders":   {"env": "prod", "team": "orders"},
+		"payments": {"env": "prod", "team": "payments"},
+		"staging":  {"env": "staging"},
+	} {
+		body, _ := json.Marshal(models.ClientInfo{CName: name, CPort: 8080, Labels: labels})
+		recorder := httptest.NewRecorder()
+		srv.registerHandler(recorder, httptest.NewRequest("POST", "/aidi/register", bytes.NewReader(body)))
+		ss.Save(HealthStatus{ClientName: name, Labels: labels})
+	}
+
+	query := "?selector=" + url.QueryEscape("env=prod,team!=payments")
+	recorder := httptest.NewRecorder()
+	srv.clientsHandler(recorder, httptest.NewRequest("GET", "/aidi/clients"+query, nil))
+	clients := []models.ClientInfo{}
+	json.NewDecoder(recorder.Body).Decode(&clients)
+	if len(clients) != 1 || clients[0].Name() != "orders" {
+		t.Errorf("clients: wanted only orders, got %+v", clients)
+	}
+
+	recorder = httptest.NewRecorder()
+	srv.clientInfoHandler(recorder, httptest.NewRequest("GET", "/aidi/health/"+query, nil))
+	statuses := []HealthStatus{}
+	json.NewDecoder(recorder.Body).Decode(&statuses)
+	if len(statuses) != 1 || statuses[0].ClientName != "orders" {
+		t.Errorf("health: wanted only orders, got %+v", statuses)
+	}
+
+	recorder = httptest.NewRecorder()
+	srv.clientInfoHandler(recorder, httptest.NewRequest("GET", "/aidi/health/?selector=%3Dprod", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("wanted 400 for bad selector, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	srv.clientInfoHandler(recorder, httptest.NewRequest("GET", "/aidi/health/orders?pretty=true", nil))
+	status := HealthStatus{}
+	json.NewDecoder(recorder.Body).Decode(&status)
+	if recorder.Code != http.StatusOK || status.ClientName != "orders" {
+		t.Errorf("wanted orders found ignoring the query, got %d %+v", recorder.Code, status)
+	}
+}
+
+func TestRegisterBadLabels(t *testing.T) {
+	cs := &memClientStore{}
+	srv := MakeServer(cs, &memStatusStore{})
+	body, _ := json.Marshal(models.ClientInfo{CName: "orders", Labels: map[string]string{"env": "prod,dev"}})
+	recorder := httptest.NewRecorder()
+	srv.registerHandler(recorder, httptest.NewRequest("POST", "/aidi/register", bytes.NewReader(body)))
+	if recorder.Code != http.StatusBadRequest || len(cs.Get()) != 0 {
+		t.Errorf("wanted registration rejected, got %d %v", recorder.Code, cs.Get())
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// maintenanceSortFields are the fields the list of maintenance windows can be sorted by.
+var maintenanceSortFields = []string{"start", "end", "id", "client"}
+
+// maintenanceRetention is how long a window is kept once it has ended, so a cancelled
+// window is shared with the cluster and an older copy from a peer does not bring it back.
+const maintenanceRetention = memberTimeout
+
+// MaintenanceStore can be implemented by a ClientStore to keep maintenance windows. Without
+// it the maintenance api answers 501 and no client is ever in maintenance. In a cluster,
+// windows are shared with every member like registrations.
+type MaintenanceStore interface {
+	SaveMaintenance(models.Maintenance)
+	DeleteMaintenance(id string) bool
+	Maintenance() []models.Maintenance
+}
+
+// currentMaintenance returns the windows that are open, still to come or recently ended,
+// removing the ones that ended over maintenanceRetention ago from the store.
+func (srv *Server) currentMaintenance(now time.Time) []models.Maintenance {
+	ms, ok := srv.clientStore.(MaintenanceStore)
+	if !ok {
+		return nil
+	}
+	ret := make([]models.Maintenance, 0)
+	for _, m := range ms.Maintenance() {
+		if m.Expired(now.Add(-maintenanceRetention)) {
+			log.Printf("server: maintenance window %s for %q has ended", m.ID, m.Reason)
+			ms.DeleteMaintenance(m.ID)
+			continue
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+// scheduledMaintenance returns the windows that are open or still to come.
+func (srv *Server) scheduledMaintenance(now time.Time) []models.Maintenance {
+	ret := make([]models.Maintenance, 0)
+	for _, m := range srv.currentMaintenance(now) {
+		if !m.Expired(now) {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// saveMaintenance stores a window and shares it with the cluster.
+func (srv *Server) saveMaintenance(ms MaintenanceStore, m models.Maintenance) {
+	ms.SaveMaintenance(m)
+	if srv.cluster != nil {
+		go srv.cluster.push(syncMessage{Maintenance: []models.Maintenance{m}})
+	}
+}
+
+// mergeMaintenance saves the windows a peer shared. A window cancelled on either side ends
+// at the earliest End, and windows past their retention are not brought back.
+func (srv *Server) mergeMaintenance(windows []models.Maintenance) {
+	ms, ok := srv.clientStore.(MaintenanceStore)
+	if !ok || len(windows) == 0 {
+		return
+	}
+	now := time.Now()
+	current := make(map[string]models.Maintenance)
+	for _, m := range ms.Maintenance() {
+		current[m.ID] = m
+	}
+	for _, m := range windows {
+		if m.Expired(now.Add(-maintenanceRetention)) {
+			continue
+		}
+		if known, ok := current[m.ID]; ok && !m.End.Before(known.End) {
+			continue
+		}
+		ms.SaveMaintenance(m)
+	}
+}
+
+// maintenanceFor is the ID of the first of windows open at now that covers the client hs is
+// the status of, or "" when there is none.
+func maintenanceFor(windows []models.Maintenance, hs HealthStatus, now time.Time) string {
+	for _, m := range windows {
+		if m.Active(now) && m.Covers(hs.ClientName, hs.ID(), hs.Labels) {
+			return m.ID
+		}
+	}
+	return ""
+}
+
+func newMaintenanceID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// maintenanceStore answers 501 when the store cannot keep maintenance windows.
+func (srv *Server) maintenanceStore(w http.ResponseWriter) (MaintenanceStore, bool) {
+	ms, ok := srv.clientStore.(MaintenanceStore)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, models.APIError{Code: models.ErrCodeNotImplemented, Message: "the client store does not keep maintenance windows"})
+	}
+	return ms, ok
+}
+
+// listMaintenanceV1 lists the windows that are open or still to come.
+func (srv *Server) listMaintenanceV1(w http.ResponseWriter, r *http.Request) {
+	if _, ok := srv.maintenanceStore(w); !ok {
+		return
+	}
+	q, ok := parseListQuery(w, r, maintenanceSortFields)
+	if !ok {
+		return
+	}
+	windows := srv.scheduledMaintenance(time.Now())
+	q.sortItems(windows, "id", func(i int, name string) interface{} {
+		switch name {
+		case "start":
+			return windows[i].Start.UnixNano()
+		case "end":
+			return windows[i].End.UnixNano()
+		case "client":
+			return windows[i].Client
+		}
+		return windows[i].ID
+	})
+
+	start, end, page := q.page(len(windows))
+	writeJSON(w, http.StatusOK, models.MaintenanceList{Items: windows[start:end], Page: page})
+}
+
+// createMaintenanceV1 schedules a window from a models.MaintenanceRequest.
+func (srv *Server) createMaintenanceV1(w http.ResponseWriter, r *http.Request) {
+	if !srv.requireAuthV1(w, r, "scheduling maintenance needs the server's auth token") {
+		return
+	}
+	ms, ok := srv.maintenanceStore(w)
+	if !ok {
+		return
+	}
+
+	req := models.MaintenanceRequest{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRegisterBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "body is not a valid maintenance window: " + err.Error()})
+		return
+	}
+	now := time.Now()
+	if errs := req.Validate(now); len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeValidation, Message: "maintenance window is invalid", Fields: errs})
+		return
+	}
+
+	m := models.Maintenance{
+		ID:       newMaintenanceID(),
+		Client:   req.Client,
+		Selector: req.Selector,
+		Start:    req.Start,
+		End:      req.End,
+		Reason:   req.Reason,
+		Created:  now,
+	}
+	if m.Start.IsZero() {
+		m.Start = now
+	}
+	log.Printf("server: scheduling maintenance window %s from %v to %v for %q", m.ID, m.Start, m.End, m.Reason)
+	srv.saveMaintenance(ms, m)
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func (srv *Server) getMaintenanceV1(w http.ResponseWriter, r *http.Request) {
+	if _, ok := srv.maintenanceStore(w); !ok {
+		return
+	}
+	id := pathParam(r, "id")
+	for _, m := range srv.scheduledMaintenance(time.Now()) {
+		if m.ID == id {
+			writeJSON(w, http.StatusOK, m)
+			return
+		}
+	}
+	notFound(w, "maintenance window", id)
+}
+
+// deleteMaintenanceV1 cancels a window, ending it now so the cancellation is shared with
+// the cluster like any other change to the window.
+func (srv *Server) deleteMaintenanceV1(w http.ResponseWriter, r *http.Request) {
+	if !srv.requireAuthV1(w, r, "cancelling maintenance needs the server's auth token") {
+		return
+	}
+	ms, ok := srv.maintenanceStore(w)
+	if !ok {
+		return
+	}
+	id := pathParam(r, "id")
+	now := time.Now()
+	for _, m := range srv.scheduledMaintenance(now) {
+		if m.ID == id {
+			m.End = now
+			srv.saveMaintenance(ms, m)
+			log.Printf("server: cancelled maintenance window %s", id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	notFound(w, "maintenance window", id)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestMaintenance(t *testing.T) {
+	cs, ss := &memClientStore{}, &memStatusStore{}
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.HealthStatus{Down: true, Status: "deploying"})
+	}))
+	defer target.Close()
+	for name, env := range map[string]string{"orders": "prod", "payments": "prod", "search": "staging"} {
+		cs.Save(models.ClientInfo{CName: name, CURL: target.URL, Labels: map[string]string{"env": env}})
+	}
+	srv := MakeServer(cs, ss)
+	srv.SetAuthToken("secret")
+	api := srv.apiV1()
+
+	body, _ := json.Marshal(models.MaintenanceRequest{Selector: "env=prod", End: time.Now().Add(time.Hour), Reason: "deploy"})
+	recorder := maintenanceCall(api, "POST", "/aidi/v1/maintenance", body, "secret")
+	window := models.Maintenance{}
+	json.NewDecoder(recorder.Body).Decode(&window)
+	if recorder.Code != http.StatusCreated || window.ID == "" || window.Start.IsZero() {
+		t.Fatalf("wanted window scheduled from now, got %d %+v", recorder.Code, window)
+	}
+
+	// still polled and recorded, but reported in maintenance rather than down
+	srv.pingAll()
+	for name, expect := range map[string]string{"orders": window.ID, "payments": window.ID, "search": ""} {
+		hs, err := ss.Find(name)
+		inMaintenance := hs.Data.Status == models.StatusMaintenance && !hs.Data.Down
+		if err != nil || hs.Maintenance != expect || inMaintenance != (expect != "") {
+			t.Errorf("%s: wanted polled status in maintenance %q, got %+v %v", name, expect, hs, err)
+		}
+	}
+	summaries := summarize(ss.FindAll())
+	if summaries[0].Name != "orders" || summaries[0].Maintenance != 1 || summaries[0].Down != 0 {
+		t.Errorf("wanted orders counted in maintenance rather than down, got %+v", summaries[0])
+	}
+
+	recorder = maintenanceCall(api, "DELETE", "/aidi/v1/maintenance/"+window.ID, nil, "secret")
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("wanted window cancelled, got %d", recorder.Code)
+	}
+	srv.pingAll()
+	if hs, _ := ss.Find("orders"); hs.Maintenance != "" || !hs.Data.Down {
+		t.Errorf("wanted maintenance over once cancelled, got %q %+v", hs.Maintenance, hs.Data)
+	}
+	if recorder := maintenanceCall(api, "DELETE", "/aidi/v1/maintenance/"+window.ID, nil, "secret"); recorder.Code != http.StatusNotFound {
+		t.Errorf("wanted 404 cancelling twice, got %d", recorder.Code)
+	}
+}
+
+// maintenanceCall sends a request to api with token as its bearer token, if any.
+func maintenanceCall(api http.Handler, method, path string, body []byte, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestMaintenanceNeedsToken(t *testing.T) {
+	cs, ss := &memClientStore{}, &memStatusStore{}
+	cs.Save(models.ClientInfo{CName: "orders", CPort: 8080})
+	srv := MakeServer(cs, ss)
+	api := srv.apiV1()
+	body, _ := json.Marshal(models.MaintenanceRequest{Client: "orders", End: time.Now().Add(time.Hour), Reason: "migration"})
+
+	// refused without a token set, and with the wrong one once it is
+	for _, token := range []string{"", "wrong"} {
+		if token != "" {
+			srv.SetAuthToken("secret")
+		}
+		for _, call := range []struct{ method, path string }{{"POST", "/aidi/v1/maintenance"}, {"DELETE", "/aidi/v1/maintenance/window"}} {
+			recorder := maintenanceCall(api, call.method, call.path, body, token)
+			resp := models.ErrorResponse{}
+			json.NewDecoder(recorder.Body).Decode(&resp)
+			if recorder.Code != http.StatusUnauthorized || resp.Error.Code != models.ErrCodeUnauthorized || recorder.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("%s %s with %q: wanted 401 %s, got %d %+v", call.method, call.path, token, models.ErrCodeUnauthorized, recorder.Code, resp.Error)
+			}
+		}
+	}
+	if windows := cs.Maintenance(); len(windows) != 0 {
+		t.Fatalf("wanted nothing scheduled without the token, got %+v", windows)
+	}
+
+	recorder := maintenanceCall(api, "POST", "/aidi/v1/maintenance", body, "secret")
+	window := models.Maintenance{}
+	if recorder.Code != http.StatusCreated || json.NewDecoder(recorder.Body).Decode(&window) != nil {
+		t.Fatalf("wanted window scheduled with the token, got %d %s", recorder.Code, recorder.Body)
+	}
+	if recorder := maintenanceCall(api, "DELETE", "/aidi/v1/maintenance/"+window.ID, nil, "secret"); recorder.Code != http.StatusNoContent {
+		t.Errorf("wanted window cancelled with the token, got %d", recorder.Code)
+	}
+}
+
+func TestMaintenanceExpires(t *testing.T) {
+	cs := &memClientStore{}
+	now := time.Now()
+	cs.SaveMaintenance(models.Maintenance{ID: "over", Client: "orders", Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)})
+	cs.SaveMaintenance(models.Maintenance{ID: "later", Client: "orders", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)})
+	cs.SaveMaintenance(models.Maintenance{ID: "now", Client: "orders@orders-1", Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+	srv := MakeServer(cs, &memStatusStore{})
+
+	windows := models.MaintenanceList{}
+	apiGet(srv.apiV1(), "GET", "/aidi/v1/maintenance", &windows)
+	if len(windows.Items) != 2 || windows.Items[0].ID != "now" || windows.Items[1].ID != "later" {
+		t.Errorf("wanted the open and coming windows by start, got %+v", windows.Items)
+	}
+	if stored := cs.Maintenance(); len(stored) != 2 {
+		t.Errorf("wanted the ended window removed from the store, got %+v", stored)
+	}
+
+	current := srv.currentMaintenance(now)
+	if id := maintenanceFor(current, HealthStatus{ClientName: "orders", Instance: "orders-1"}, now); id != "now" {
+		t.Errorf("wanted the open window by id, got %q", id)
+	}
+	if id := maintenanceFor(current, HealthStatus{ClientName: "orders", Instance: "orders-2"}, now); id != "" {
+		t.Errorf("wanted no window open for another instance, got %q", id)
+	}
+}
+
+func TestMaintenanceReplicated(t *testing.T) {
+	peerStore := &memClientStore{}
+	peer := MakeServer(peerStore, &memStatusStore{})
+	peer.JoinCluster(MakeCluster("127.0.0.1:9901", []string{"127.0.0.1:9900"}, "secret"))
+	now := time.Now()
+	window := models.Maintenance{ID: "deploy", Client: "orders", Start: now, End: now.Add(time.Hour)}
+
+	sync := func(windows ...models.Maintenance) {
+		body, _ := json.Marshal(syncMessage{Member: "127.0.0.1:9900", Maintenance: windows})
+		if recorder := syncFrom(peer, "127.0.0.1:41000", "secret", body); recorder.Code != http.StatusNoContent {
+			t.Fatalf("wanted sync accepted, got %d", recorder.Code)
+		}
+	}
+	sync(window)
+	if windows := peer.scheduledMaintenance(now); len(windows) != 1 || windows[0].ID != "deploy" {
+		t.Fatalf("wanted window shared with peer, got %+v", windows)
+	}
+
+	// once cancelled, an older copy does not reopen it
+	cancelled := window
+	cancelled.End = now
+	sync(cancelled)
+	sync(window)
+	if windows := peer.scheduledMaintenance(now.Add(time.Second)); len(windows) != 0 {
+		t.Errorf("wanted cancellation shared with peer, got %+v", windows)
+	}
+	if stored := peerStore.Maintenance(); len(stored) != 1 || !stored[0].End.Equal(now) {
+		t.Errorf("wanted cancelled window kept to share, got %+v", stored)
+	}
+}
+
+func TestMaintenanceUnsupported(t *testing.T) {
+	srv := MakeServer(&mockClientStore{}, &mockStatusStore{})
+	resp := models.ErrorResponse{}
+	recorder := apiGet(srv.apiV1(), "GET", "/aidi/v1/maintenance", &resp)
+	if recorder.Code != http.StatusNotImplemented || resp.Error.Code != models.ErrCodeNotImplemented {
+		t.Errorf("wanted 501, got %d %+v", recorder.Code, resp)
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// operation documents a route for the OpenAPI document. Responses maps each success status
+// to a value of the type returned, nil for no body; every operation may also answer with a
+// models.ErrorResponse.
+type operation struct {
+	id        string
+	summary   string
+	params    []param
+	body      interface{}
+	responses map[int]interface{}
+}
+
+// param is a query parameter of an operation. Kind is its OpenAPI type.
+type param struct {
+	name        string
+	kind        string
+	description string
+}
+
+var (
+	selectorParam = param{"selector", "string", "label selector, ie env=prod,team!=payments"}
+	limitParam    = param{"limit", "integer", "most items to return, " + strconv.Itoa(defaultLimit) + " by default and at most " + strconv.Itoa(maxLimit)}
+	offsetParam   = param{"offset", "integer", "number of items to skip"}
+	pollParam     = param{"poll", "boolean", "when true, answer once the next round of polling has been saved"}
+)
+
+// sortParam documents the sort query of a list accepting the given fields.
+func sortParam(fields []string) param {
+	return param{"sort", "string", "field to sort by, one of " + strings.Join(fields, ", ") + ", prefixed by - for descending order"}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// openAPI generates the OpenAPI 3 document describing routes, with the schema of every
+// request and response body built from its Go type as encoding/json would write it.
+func openAPI(routes []*route) map[string]interface{} {
+	sb := schemaBuilder{components: make(map[string]interface{})}
+	errorSchema := sb.schema(reflect.TypeOf(models.ErrorResponse{}))
+
+	paths := make(map[string]interface{})
+	for _, rte := range routes {
+		params := []interface{}{}
+		for _, segment := range rte.segments {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				params = append(params, map[string]interface{}{
+					"name":     segment[1 : len(segment)-1],
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+		for _, p := range rte.op.params {
+			params = append(params, map[string]interface{}{
+				"name":        p.name,
+				"in":          "query",
+				"description": p.description,
+				"schema":      map[string]interface{}{"type": p.kind},
+			})
+		}
+
+		responses := map[string]interface{}{
+			"default": jsonContent("error", errorSchema),
+		}
+		for status, body := range rte.op.responses {
+			if body == nil {
+				responses[strconv.Itoa(status)] = map[string]interface{}{"description": http.StatusText(status)}
+				continue
+			}
+			responses[strconv.Itoa(status)] = jsonContent(http.StatusText(status), sb.schema(reflect.TypeOf(body)))
+		}
+
+		op := map[string]interface{}{
+			"operationId": rte.op.id,
+			"summary":     rte.op.summary,
+			"parameters":  params,
+			"responses":   responses,
+		}
+		if rte.op.body != nil {
+			body := jsonContent("", sb.schema(reflect.TypeOf(rte.op.body)))
+			body["required"] = true
+			delete(body, "description")
+			op["requestBody"] = body
+		}
+
+		item, ok := paths[rte.pattern].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[rte.pattern] = item
+		}
+		item[strings.ToLower(rte.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "aidi",
+			"version": "1",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": sb.components},
+	}
+}
+
+func jsonContent(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// schemaBuilder turns Go types into schemas, collecting every named struct as a component.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+func (sb *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": sb.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": sb.schema(t.Elem())}
+	case reflect.Ptr:
+		return nullable(sb.schema(t.Elem()))
+	case reflect.Struct:
+		if t.Name() == "" {
+			return sb.object(t)
+		}
+		if _, ok := sb.components[t.Name()]; !ok {
+			// claimed before the fields are built so recursive types refer back to it
+			sb.components[t.Name()] = nil
+			sb.components[t.Name()] = sb.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// object is the schema of a struct. Fields without omitempty are always written so they
+// are required, and nil slices, maps and pointers among them are written as null.
+func (sb *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	sb.fields(t, properties, &required)
+
+	ret := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		ret["required"] = required
+	}
+	return ret
+}
+
+func (sb *schemaBuilder) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, opts = tag[:comma], tag[comma:]
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			sb.fields(field.Type, properties, required)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := sb.schema(field.Type)
+		if strings.Contains(opts, ",omitempty") {
+			properties[name] = schema
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Slice, reflect.Map:
+			schema = nullable(schema)
+		}
+		properties[name] = schema
+		*required = append(*required, name)
+	}
+}
+
+// nullable allows null in place of schema, wrapping references as OpenAPI 3.0 ignores
+// anything next to a $ref.
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+	}
+	schema["nullable"] = true
+	return schema
+}
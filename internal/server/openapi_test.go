@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// TestOpenAPI checks the served document against real responses of every route it lists.
+func TestOpenAPI(t *testing.T) {
+	cs, ss := &memClientStore{}, &memStatusStore{}
+	srv := MakeServer(cs, ss)
+	srv.SetAuthToken("secret")
+	srv.beat()
+	labels := map[string]string{"env": "prod"}
+	cs.Save(models.ClientInfo{CName: "orders", Instance: "orders-1", CPort: 8080, CURL: "http://10.0.0.5:8080/metrics/health", Origin: models.OriginRegistered, Labels: labels, Metadata: map[string]string{"version": "1.2.0"}})
+	cs.Save(models.ClientInfo{CName: "site", Origin: "file", Probe: &models.Probe{Kind: models.ProbeHTTP, Target: "https://example.com"}})
+	ss.Save(HealthStatus{ClientName: "orders", Instance: "orders-1", Data: models.MakeHealthStatus(), Updated: 100, Origin: models.OriginRegistered, Labels: labels})
+	ss.Save(HealthStatus{ClientName: "site", Data: runProbe(context.Background(), models.Probe{Kind: models.ProbeTCP, Target: "127.0.0.1:1"}), Updated: 100, Origin: "file", Maintenance: "window"})
+	cs.SaveMaintenance(models.Maintenance{ID: "window", Client: "site", Start: time.Now(), End: time.Now().Add(time.Hour), Reason: "migration", Created: time.Now()})
+	api := srv.apiV1()
+
+	recorder := apiGet(api, "GET", "/aidi/v1/openapi.json", nil)
+	spec := map[string]interface{}{}
+	if err := json.NewDecoder(recorder.Body).Decode(&spec); err != nil {
+		t.Fatalf("could not decode document: %v", err)
+	}
+	if spec["openapi"] != "3.0.3" {
+		t.Errorf("wanted openapi 3.0.3, got %v", spec["openapi"])
+	}
+
+	calls := []struct {
+		method, pattern, path, body string
+		status                      int
+	}{
+		{"POST", "/aidi/v1/register", "/aidi/v1/register", `{"name":"users","port":8080,"labels":{"env":"prod"}}`, http.StatusCreated},
+		{"POST", "/aidi/v1/register", "/aidi/v1/register", `{"name":"users"}`, http.StatusBadRequest},
+		{"GET", "/aidi/v1/ready", "/aidi/v1/ready", "", http.StatusOK},
+		{"GET", "/aidi/v1/clients", "/aidi/v1/clients?sort=-id", "", http.StatusOK},
+		{"GET", "/aidi/v1/clients", "/aidi/v1/clients?limit=x", "", http.StatusBadRequest},
+		{"GET", "/aidi/v1/clients/{id}", "/aidi/v1/clients/orders@orders-1", "", http.StatusOK},
+		{"GET", "/aidi/v1/clients/{id}", "/aidi/v1/clients/site", "", http.StatusOK},
+		{"GET", "/aidi/v1/clients/{id}", "/aidi/v1/clients/nothing", "", http.StatusNotFound},
+		{"GET", "/aidi/v1/statuses", "/aidi/v1/statuses", "", http.StatusOK},
+		{"GET", "/aidi/v1/statuses/{id}", "/aidi/v1/statuses/orders@orders-1", "", http.StatusOK},
+		{"GET", "/aidi/v1/statuses/{id}", "/aidi/v1/statuses/site", "", http.StatusOK},
+		{"GET", "/aidi/v1/services", "/aidi/v1/services?selector=env%3Dprod", "", http.StatusOK},
+		{"GET", "/aidi/v1/services/{name}", "/aidi/v1/services/orders", "", http.StatusOK},
+		{"POST", "/aidi/v1/maintenance", "/aidi/v1/maintenance", `{"selector":"env=prod","end":"2100-01-01T00:00:00Z","reason":"deploy"}`, http.StatusCreated},
+		{"POST", "/aidi/v1/maintenance", "/aidi/v1/maintenance", `{"client":"orders"}`, http.StatusBadRequest},
+		{"GET", "/aidi/v1/maintenance", "/aidi/v1/maintenance?sort=-end", "", http.StatusOK},
+		{"GET", "/aidi/v1/maintenance/{id}", "/aidi/v1/maintenance/window", "", http.StatusOK},
+		{"DELETE", "/aidi/v1/maintenance/{id}", "/aidi/v1/maintenance/window", "", http.StatusNoContent},
+		{"GET", "/aidi/v1/openapi.json", "/aidi/v1/openapi.json", "", http.StatusOK},
+	}
+	called := make(map[string]bool)
+	for _, c := range calls {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		request.Header.Set("Authorization", "Bearer secret")
+		api.ServeHTTP(recorder, request)
+		if recorder.Code != c.status {
+			t.Errorf("%s %s: wanted %d, got %d %s", c.method, c.path, c.status, recorder.Code, recorder.Body)
+			continue
+		}
+		called[c.method+" "+c.pattern] = true
+		if c.status == http.StatusNoContent {
+			continue
+		}
+
+		schema, err := responseSchema(spec, c.method, c.pattern, c.status)
+		if err != nil {
+			t.Errorf("%s %s: %v", c.method, c.path, err)
+			continue
+		}
+		var body interface{}
+		if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+			t.Errorf("%s %s: could not decode body: %v", c.method, c.path, err)
+			continue
+		}
+		for _, problem := range validateSchema(spec, schema, body, "body") {
+			t.Errorf("%s %s: %s", c.method, c.path, problem)
+		}
+	}
+
+	// every documented operation has been checked
+	for pattern, item := range spec["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			if !called[strings.ToUpper(method)+" "+pattern] {
+				t.Errorf("%s %s is documented but not checked", method, pattern)
+			}
+		}
+	}
+}
+
+func responseSchema(spec map[string]interface{}, method, pattern string, status int) (map[string]interface{}, error) {
+	item, ok := spec["paths"].(map[string]interface{})[pattern].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is not documented", pattern)
+	}
+	op, ok := item[strings.ToLower(method)].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s is not documented", method, pattern)
+	}
+	responses := op["responses"].(map[string]interface{})
+	resp, ok := responses[strconv.Itoa(status)].(map[string]interface{})
+	if !ok {
+		resp = responses["default"].(map[string]interface{})
+	}
+	content := resp["content"].(map[string]interface{})["application/json"].(map[string]interface{})
+	return content["schema"].(map[string]interface{}), nil
+}
+
+// validateSchema checks a decoded json value against the subset of OpenAPI 3.0 schemas the
+// generator writes, returning every mismatch.
+func validateSchema(spec map[string]interface{}, schema map[string]interface{}, value interface{}, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return []string{at + ": unresolved " + ref}
+		}
+		return validateSchema(spec, resolved, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + ": is null"}
+	}
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		problems := []string{}
+		for _, sub := range all {
+			problems = append(problems, validateSchema(spec, sub.(map[string]interface{}), value, at)...)
+		}
+		return problems
+	}
+
+	mismatch := func(kind string) []string {
+		return []string{fmt.Sprintf("%s: wanted %s, got %T", at, kind, value)}
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch("object")
+		}
+		problems := []string{}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				problems = append(problems, at+": missing "+name.(string))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if sub, ok := properties[key].(map[string]interface{}); ok {
+				problems = append(problems, validateSchema(spec, sub, obj[key], at+"."+key)...)
+			} else if sub, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				problems = append(problems, validateSchema(spec, sub, obj[key], at+"."+key)...)
+			} else if schema["additionalProperties"] == false {
+				problems = append(problems, at+": undocumented "+key)
+			}
+		}
+		return problems
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return mismatch("array")
+		}
+		problems := []string{}
+		for i, item := range arr {
+			problems = append(problems, validateSchema(spec, schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return problems
+	case "string":
+		if _, ok := value.(string); !ok {
+			return mismatch("string")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch("boolean")
+		}
+	case "number", "integer":
+		n, ok := value.(float64)
+		if !ok {
+			return mismatch(schema["type"].(string))
+		}
+		if schema["type"] == "integer" && n != math.Trunc(n) {
+			return mismatch("integer")
+		}
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			return []string{fmt.Sprintf("%s: %v is below %v", at, n, min)}
+		}
+	}
+	return nil
+}
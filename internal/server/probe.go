@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// defaultProbeTimeout bounds a probe that does not set its own timeout.
+const defaultProbeTimeout = 5 * time.Second
+
+// maxProbeBody is how much of an http response is read to match ExpectBody.
+const maxProbeBody = 1 << 20
+
+// probeClient does not follow redirects so a probe sees the status the target answers with.
+var probeClient = http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// runProbe checks a target with p, reporting the outcome as a HealthStatus. Each thing
+// verified is a critical check, so the status is down as soon as one fails.
+func runProbe(ctx context.Context, p models.Probe) models.HealthStatus {
+	timeout := defaultProbeTimeout
+	if p.Timeout > 0 && p.Timeout <= models.MaxProbeTimeout {
+		timeout = time.Duration(p.Timeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result := &models.ProbeResult{Kind: p.Kind, Target: p.Target}
+	pr := prober{probe: p, result: result}
+	start := time.Now()
+	switch p.Kind {
+	case models.ProbeHTTP:
+		pr.http(ctx)
+	case models.ProbeTCP:
+		pr.tcp(ctx)
+	case models.ProbeDNS:
+		pr.dns(ctx)
+	default:
+		pr.check("probe", fmt.Errorf("unknown probe kind %q", p.Kind))
+	}
+	result.Duration = float64(time.Since(start)) / float64(time.Millisecond)
+
+	hs := models.HealthStatus{Status: "ok", Checks: pr.checks, Probe: result}
+	failed := []string{}
+	for _, check := range pr.checks {
+		if check.Status == models.CheckFail {
+			failed = append(failed, check.Name)
+		}
+	}
+	if len(failed) > 0 {
+		hs.Down, hs.Status = true, "down: "+strings.Join(failed, ", ")
+	}
+	return hs
+}
+
+// prober collects the checks made by one probe run.
+type prober struct {
+	probe  models.Probe
+	result *models.ProbeResult
+	checks []models.CheckResult
+}
+
+func (pr *prober) check(name string, err error) bool {
+	check := models.CheckResult{Name: name, Status: models.CheckPass, Critical: true, Checked: time.Now().Unix()}
+	if err != nil {
+		check.Status, check.Error = models.CheckFail, err.Error()
+	}
+	pr.checks = append(pr.checks, check)
+	return err == nil
+}
+
+func (pr *prober) http(ctx context.Context) {
+	var resp *http.Response
+	req, err := http.NewRequest("GET", pr.probe.Target, nil)
+	if err == nil {
+		resp, err = probeClient.Do(req.WithContext(ctx))
+	}
+	if !pr.check("request", err) {
+		return
+	}
+	defer resp.Body.Close()
+	pr.result.StatusCode = resp.StatusCode
+
+	if expect := pr.probe.ExpectStatus; expect != 0 && resp.StatusCode != expect {
+		pr.check("status", fmt.Errorf("wanted status %d, got %d", expect, resp.StatusCode))
+	} else if expect == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		pr.check("status", fmt.Errorf("wanted a 2xx status, got %d", resp.StatusCode))
+	} else {
+		pr.check("status", nil)
+	}
+
+	if pr.probe.ExpectBody != "" {
+		pr.check("body", matchBody(resp.Body, pr.probe.ExpectBody))
+	}
+
+	if resp.TLS != nil {
+		pr.check("certificate", pr.certificate(resp.TLS))
+	}
+}
+
+func matchBody(body io.Reader, expect string) error {
+	pattern, err := regexp.Compile(expect)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxProbeBody))
+	if err != nil {
+		return err
+	}
+	if !pattern.Match(data) {
+		return fmt.Errorf("body did not match %q", expect)
+	}
+	return nil
+}
+
+func (pr *prober) certificate(state *tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("no certificate presented")
+	}
+	expiry := state.PeerCertificates[0].NotAfter
+	pr.result.CertExpiry = expiry.Unix()
+
+	days := pr.probe.CertExpiryDays
+	if days == 0 {
+		days = models.DefaultCertExpiryDays
+	}
+	if left := time.Until(expiry); left < time.Duration(days)*24*time.Hour {
+		return fmt.Errorf("certificate expires in %.1f days", left.Hours()/24)
+	}
+	return nil
+}
+
+func (pr *prober) tcp(ctx context.Context) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", pr.probe.Target)
+	if pr.check("connect", err) {
+		conn.Close()
+	}
+}
+
+func (pr *prober) dns(ctx context.Context) {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, pr.probe.Target)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("%s resolved to no addresses", pr.probe.Target)
+	}
+	pr.result.Addresses = addrs
+	pr.check("resolve", err)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestRunProbe(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status": "UP"}`))
+	}))
+	defer target.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secure.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	// trust the test certificate
+	transport := probeClient.Transport
+	probeClient.Transport = secure.Client().Transport
+	defer func() { probeClient.Transport = transport }()
+
+	var testCases = []struct {
+		name   string
+		probe  models.Probe
+		down   bool
+		status string
+	}{
+		{"http", models.Probe{Kind: models.ProbeHTTP, Target: target.URL, ExpectBody: `"status":\s*"UP"`}, false, "ok"},
+		{"httpstatus", models.Probe{Kind: models.ProbeHTTP, Target: target.URL + "/missing"}, true, "down: status"},
+		{"httpexpected", models.Probe{Kind: models.ProbeHTTP, Target: target.URL + "/missing", ExpectStatus: 404}, false, "ok"},
+		{"httpbody", models.Probe{Kind: models.ProbeHTTP, Target: target.URL, ExpectBody: "DOWN"}, true, "down: body"},
+		{"httpunreachable", models.Probe{Kind: models.ProbeHTTP, Target: closed.URL}, true, "down: request"},
+		{"https", models.Probe{Kind: models.ProbeHTTP, Target: secure.URL}, false, "ok"},
+		// the test certificate is not valid for another century
+		{"httpsexpiring", models.Probe{Kind: models.ProbeHTTP, Target: secure.URL, CertExpiryDays: 365 * 100}, true, "down: certificate"},
+		{"tcp", models.Probe{Kind: models.ProbeTCP, Target: target.Listener.Addr().String()}, false, "ok"},
+		{"tcpclosed", models.Probe{Kind: models.ProbeTCP, Target: closed.Listener.Addr().String()}, true, "down: connect"},
+		{"dns", models.Probe{Kind: models.ProbeDNS, Target: "localhost"}, false, "ok"},
+		{"dnsmissing", models.Probe{Kind: models.ProbeDNS, Target: "missing.invalid"}, true, "down: resolve"},
+	}
+	for _, test := range testCases {
+		hs := runProbe(context.Background(), test.probe)
+		if hs.Down != test.down || hs.Status != test.status {
+			t.Errorf("%s: wanted down %t %q, got %t %q %+v", test.name, test.down, test.status, hs.Down, hs.Status, hs.Checks)
+		}
+		if hs.Probe == nil || hs.Probe.Kind != test.probe.Kind || hs.Probe.Duration <= 0 {
+			t.Errorf("%s: wanted probe result, got %+v", test.name, hs.Probe)
+		}
+	}
+}
+
+func TestPollProbe(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	// the url is ignored for probe targets
+	hs := poll(context.Background(), models.ClientInfo{CName: "legacy", CURL: "http://127.0.0.1:1", Probe: &models.Probe{Kind: models.ProbeHTTP, Target: target.URL}})
+	if hs.Down || hs.Probe == nil || hs.Probe.StatusCode != 200 {
+		t.Errorf("wanted probe run against target, got %+v", hs)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// pollInterval is how often the server pings every registered client.
+const pollInterval = 1 * time.Second
+
+// heartbeatTimeout is how long the poll loop can go without ticking before the server
+// reports itself not ready.
+const heartbeatTimeout = 5 * pollInterval
+
+// readyTimeout bounds each readiness check.
+const readyTimeout = 2 * time.Second
+
+// ReadinessCheck reports whether something the server depends on is usable.
+type ReadinessCheck func(ctx context.Context) error
+
+// Pinger can be implemented by a ClientStore or StatusStore to have its connectivity checked
+// by /aidi/ready. The memory stores always succeed; a database backed store should make a
+// round trip.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ReadyResponse is the body of /aidi/ready.
+type ReadyResponse struct {
+	Ready  bool                 `json:"ready"`
+	Checks []models.CheckResult `json:"checks"`
+}
+
+// AddReadinessCheck registers an extra dependency to be checked by /aidi/ready. A check
+// registered under an existing name replaces it.
+func (srv *Server) AddReadinessCheck(name string, check ReadinessCheck) {
+	srv.readyMutex.Lock()
+	defer srv.readyMutex.Unlock()
+	if srv.readyChecks == nil {
+		srv.readyChecks = make(map[string]ReadinessCheck)
+	}
+	srv.readyChecks[name] = check
+}
+
+// HTTPDependency provides a ReadinessCheck that passes when url answers a GET with a 2xx.
+func HTTPDependency(url string) ReadinessCheck {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := httpcli.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("got status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// beat records that the poll loop is alive.
+func (srv *Server) beat() {
+	atomic.StoreInt64(&srv.heartbeat, time.Now().UnixNano())
+}
+
+func (srv *Server) checkHeartbeat(ctx context.Context) error {
+	last := atomic.LoadInt64(&srv.heartbeat)
+	if last == 0 {
+		return errors.New("poll loop has not started")
+	}
+	if since := time.Since(time.Unix(0, last)); since > heartbeatTimeout {
+		return fmt.Errorf("poll loop last ran %v ago", since.Round(time.Millisecond))
+	}
+	return nil
+}
+
+func pingStore(store interface{}) ReadinessCheck {
+	return func(ctx context.Context) error {
+		if pinger, ok := store.(Pinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	}
+}
+
+// readiness runs the built in checks and every registered dependency concurrently. A check
+// that panics fails rather than taking the server down.
+func (srv *Server) readiness(ctx context.Context) ReadyResponse {
+	checks := map[string]ReadinessCheck{
+		"client-store": pingStore(srv.clientStore),
+		"status-store": pingStore(srv.statusStore),
+		"poll-loop":    srv.checkHeartbeat,
+	}
+	srv.readyMutex.RLock()
+	for name, check := range srv.readyChecks {
+		checks[name] = check
+	}
+	srv.readyMutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	results := make(chan models.CheckResult, len(checks))
+	for name, check := range checks {
+		go func(name string, check ReadinessCheck) {
+			start := time.Now()
+			errchan := make(chan error, 1)
+			go func() {
+				defer func() {
+					if err := recover(); err != nil {
+						errchan <- fmt.Errorf("check panicked: %v", err)
+					}
+				}()
+				errchan <- check(ctx)
+			}()
+
+			var err error
+			select {
+			case err = <-errchan:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			result := models.CheckResult{
+				Name:     name,
+				Status:   models.CheckPass,
+				Critical: true,
+				Duration: float64(time.Since(start)) / float64(time.Millisecond),
+				Checked:  start.Unix(),
+			}
+			if err != nil {
+				result.Status = models.CheckFail
+				result.Error = err.Error()
+			}
+			results <- result
+		}(name, check)
+	}
+
+	ret := ReadyResponse{Ready: true}
+	for range checks {
+		result := <-results
+		if result.Status == models.CheckFail {
+			ret.Ready = false
+		}
+		ret.Checks = append(ret.Checks, result)
+	}
+	sort.Slice(ret.Checks, func(i, j int) bool { return ret.Checks[i].Name < ret.Checks[j].Name })
+	return ret
+}
+
+func (srv *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ready := srv.readiness(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready.Ready {
+		log.Printf("server: not ready %v", ready.Checks)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(&ready); err != nil {
+		log.Printf("server: encountered error encoding json: %v", err)
+	}
+}
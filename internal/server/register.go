@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// maxRegisterBody bounds the size of a registration.
+const maxRegisterBody = 64 << 10
+
+// writeJSON sends body as json with the given status.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("server: encountered error encoding json: %v", err)
+	}
+}
+
+// writeError sends err in the error body of the versioned api.
+func writeError(w http.ResponseWriter, status int, err models.APIError) {
+	writeJSON(w, status, models.ErrorResponse{Error: err})
+}
+
+// registerV1Handler registers a client from a models.RegisterRequest. Unknown fields and
+// invalid values are rejected with every problem listed, and a registration answers with
+// the ID assigned, the url that will be polled and how often. Probes and urls at a host other
+// than the caller's make the server call out to any target, so registering either needs the
+// server's auth token.
+func (srv *Server) registerV1Handler(w http.ResponseWriter, r *http.Request) {
+	req := models.RegisterRequest{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRegisterBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "body is not a valid registration: " + err.Error()})
+		return
+	}
+	if decoder.More() {
+		writeError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeBadRequest, Message: "body holds more than one registration"})
+		return
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, models.APIError{Code: models.ErrCodeValidation, Message: "registration is invalid", Fields: errs})
+		return
+	}
+	if req.Probe != nil && !srv.requireAuthV1(w, r, "registering a probe needs the server's auth token") {
+		return
+	}
+	if advertisedElsewhere(req, r.RemoteAddr) && !srv.requireAuthV1(w, r, "advertising a url at another host needs the server's auth token") {
+		return
+	}
+
+	info := clientFromRequest(req, r.RemoteAddr)
+	srv.saveClient(info)
+	writeJSON(w, http.StatusCreated, models.RegisterResponse{
+		ID:           info.ID(),
+		URL:          info.URL(),
+		PollInterval: pollInterval.Seconds(),
+	})
+}
+
+// clientFromRequest builds the ClientInfo for a validated request. Without an advertised url
+// the client is polled on its port at the address the request came from, which also names
+// the instance when the client did not.
+func clientFromRequest(req models.RegisterRequest, remoteAddr string) models.ClientInfo {
+	info := models.ClientInfo{
+		CName:    req.Name,
+		Instance: req.Instance,
+		CPort:    req.Port,
+		CURL:     req.URL,
+		Origin:   models.OriginRegistered,
+		Probe:    req.Probe,
+		Labels:   req.Labels,
+		Metadata: req.Metadata,
+	}
+
+	switch {
+	case req.Probe != nil:
+		// probe targets are checked where they say, not where the registration came from
+	case req.URL != "":
+		if u, err := url.Parse(req.URL); err == nil && info.Instance == "" {
+			info.Instance = u.Host
+		}
+	default:
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			host = remoteAddr
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(req.Port))
+		info.CURL = "http://" + addr + DefaultHealthPath
+		if info.Instance == "" {
+			info.Instance = addr
+		}
+	}
+
+	return info
+}
+
+// advertisedElsewhere reports whether req asks to be polled at a url whose host is not the
+// address the request came from.
+func advertisedElsewhere(req models.RegisterRequest, remoteAddr string) bool {
+	if req.URL == "" || req.Probe != nil {
+		return false
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if advertised, remote := net.ParseIP(u.Hostname()), net.ParseIP(host); advertised != nil && remote != nil {
+		return !advertised.Equal(remote)
+	}
+	return !strings.EqualFold(u.Hostname(), host)
+}
+
+// saveClient stores a registration and shares it with the cluster.
+func (srv *Server) saveClient(info models.ClientInfo) {
+	log.Printf("server: registering %s to be polled at %s", info.ID(), info.URL())
+	srv.clientStore.Save(info)
+	if srv.cluster != nil {
+		go srv.cluster.push(syncMessage{Clients: []models.ClientInfo{info}})
+	}
+}
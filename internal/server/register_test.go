@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func registerV1(srv *Server, remoteAddr, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/aidi/v1/register", strings.NewReader(body))
+	request.RemoteAddr = remoteAddr
+	srv.registerV1Handler(recorder, request)
+	return recorder
+}
+
+func TestRegisterV1(t *testing.T) {
+	cases := []struct {
+		name, remote, body string
+		id, url            string
+	}{
+		{"ipv4", "10.0.0.5:41000", `{"name":"orders","port":8080}`, "orders@10.0.0.5:8080", "http://10.0.0.5:8080/metrics/health"},
+		{"ipv6", "[fd00::1]:41000", `{"name":"orders","port":8080}`, "orders@[fd00::1]:8080", "http://[fd00::1]:8080/metrics/health"},
+		{"named", "10.0.0.5:41000", `{"name":"orders","instance":"orders-1","port":8080}`, "orders@orders-1", "http://10.0.0.5:8080/metrics/health"},
+		{"advertised", "10.0.0.5:41000", `{"name":"orders","url":"https://10.0.0.5:8443/health"}`, "orders@10.0.0.5:8443", "https://10.0.0.5:8443/health"},
+	}
+	for _, c := range cases {
+		cs := &memClientStore{}
+		srv := MakeServer(cs, &memStatusStore{})
+		recorder := registerV1(srv, c.remote, c.body)
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("%s: wanted 201, got %d %s", c.name, recorder.Code, recorder.Body)
+			continue
+		}
+		resp := models.RegisterResponse{}
+		json.NewDecoder(recorder.Body).Decode(&resp)
+		if resp.ID != c.id || resp.URL != c.url || resp.PollInterval != pollInterval.Seconds() {
+			t.Errorf("%s: wanted %s at %s, got %+v", c.name, c.id, c.url, resp)
+		}
+		if clients := cs.Get(); len(clients) != 1 || clients[0].ID() != c.id || clients[0].Origin != models.OriginRegistered {
+			t.Errorf("%s: wanted %s saved, got %+v", c.name, c.id, clients)
+		}
+	}
+}
+
+func TestRegisterV1Rejects(t *testing.T) {
+	cases := []struct {
+		name, body string
+		code       string
+		fields     []string
+	}{
+		{"unknown-field", `{"name":"orders","port":8080,"prot":1}`, models.ErrCodeBadRequest, nil},
+		{"not-json", `orders`, models.ErrCodeBadRequest, nil},
+		{"two-objects", `{"name":"a","port":1}{"name":"b","port":1}`, models.ErrCodeBadRequest, nil},
+		{"invalid", `{"name":"or ders","port":70000,"labels":{"env":"a,b"}}`, models.ErrCodeValidation, []string{"name", "port", "labels"}},
+		{"no-target", `{"name":"orders"}`, models.ErrCodeValidation, []string{"port"}},
+		{"probe-and-port", `{"name":"site","port":80,"probe":{"kind":"tcp","target":"db:5432"}}`, models.ErrCodeValidation, []string{"probe"}},
+	}
+	for _, c := range cases {
+		cs := &memClientStore{}
+		srv := MakeServer(cs, &memStatusStore{})
+		recorder := registerV1(srv, "10.0.0.5:41000", c.body)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: wanted 400, got %d", c.name, recorder.Code)
+		}
+		resp := models.ErrorResponse{}
+		json.NewDecoder(recorder.Body).Decode(&resp)
+		fields := []string{}
+		for _, field := range resp.Error.Fields {
+			fields = append(fields, field.Field)
+		}
+		if resp.Error.Code != c.code || strings.Join(fields, ",") != strings.Join(c.fields, ",") {
+			t.Errorf("%s: wanted %s on %v, got %+v", c.name, c.code, c.fields, resp.Error)
+		}
+		if len(cs.Get()) != 0 {
+			t.Errorf("%s: wanted nothing saved, got %v", c.name, cs.Get())
+		}
+	}
+}
+
+func TestRegisterLegacyBadJSON(t *testing.T) {
+	cs := &memClientStore{}
+	srv := MakeServer(cs, &memStatusStore{})
+	recorder := httptest.NewRecorder()
+	srv.registerHandler(recorder, httptest.NewRequest("POST", "/aidi/register", bytes.NewReader([]byte("{"))))
+	if recorder.Code != http.StatusBadRequest || len(cs.Get()) != 0 {
+		t.Errorf("wanted bad json rejected, got %d %v", recorder.Code, cs.Get())
+	}
+}
+
+func TestRegisterLegacyNames(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+	}{
+		{"orders service", http.StatusCreated},
+		{"_orders", http.StatusCreated},
+		{"orders@1", http.StatusBadRequest},
+		{"team/orders", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		cs := &memClientStore{}
+		srv := MakeServer(cs, &memStatusStore{})
+		body, _ := json.Marshal(models.ClientInfo{CName: c.name, CPort: 8080})
+		recorder := httptest.NewRecorder()
+		srv.registerHandler(recorder, httptest.NewRequest("POST", "/aidi/register", bytes.NewReader(body)))
+		if recorder.Code != c.status {
+			t.Errorf("%q: wanted %d, got %d", c.name, c.status, recorder.Code)
+		}
+	}
+}
+
+func TestRegisterProbeNeedsToken(t *testing.T) {
+	body := `{"name":"site","probe":{"kind":"tcp","target":"db:5432"}}`
+	cases := []struct {
+		name, token, auth string
+		status            int
+	}{
+		{"no-token-set", "", "Bearer ", http.StatusUnauthorized},
+		{"missing", "secret", "", http.StatusUnauthorized},
+		{"wrong", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"authorized", "secret", "Bearer secret", http.StatusCreated},
+	}
+	for _, c := range cases {
+		cs := &memClientStore{}
+		srv := MakeServer(cs, &memStatusStore{})
+		srv.SetAuthToken(c.token)
+		for _, path := range []string{"/aidi/v1/register", "/aidi/register"} {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("POST", path, strings.NewReader(body))
+			request.Header.Set("Authorization", c.auth)
+			if path == "/aidi/register" {
+				srv.registerHandler(recorder, request)
+			} else {
+				srv.registerV1Handler(recorder, request)
+			}
+			if recorder.Code != c.status {
+				t.Errorf("%s %s: wanted %d, got %d", c.name, path, c.status, recorder.Code)
+			}
+		}
+		if saved := len(cs.Get()) == 1; saved != (c.status == http.StatusCreated) {
+			t.Errorf("%s: wanted saved %t, got %+v", c.name, c.status == http.StatusCreated, cs.Get())
+		}
+	}
+}
+
+func TestRegisterAdvertisedElsewhereNeedsToken(t *testing.T) {
+	cases := []struct {
+		name, remote, url, auth string
+		status                  int
+	}{
+		{"same-host", "10.0.0.5:41000", "http://10.0.0.5:8080/health", "", http.StatusCreated},
+		{"same-ipv6", "[fd00::1]:41000", "http://[fd00:0::1]:8080/health", "", http.StatusCreated},
+		{"other-ip", "10.0.0.5:41000", "http://169.254.169.254/latest/meta-data", "", http.StatusUnauthorized},
+		{"other-name", "10.0.0.5:41000", "https://orders.example.com/health", "Bearer wrong", http.StatusUnauthorized},
+		{"authorized", "10.0.0.5:41000", "https://orders.example.com/health", "Bearer secret", http.StatusCreated},
+	}
+	for _, c := range cases {
+		cs := &memClientStore{}
+		srv := MakeServer(cs, &memStatusStore{})
+		srv.SetAuthToken("secret")
+		for _, path := range []string{"/aidi/v1/register", "/aidi/register"} {
+			body, _ := json.Marshal(models.RegisterRequest{Name: "orders", URL: c.url})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("POST", path, bytes.NewReader(body))
+			request.RemoteAddr = c.remote
+			request.Header.Set("Authorization", c.auth)
+			if path == "/aidi/register" {
+				srv.registerHandler(recorder, request)
+			} else {
+				srv.registerV1Handler(recorder, request)
+			}
+			if recorder.Code != c.status {
+				t.Errorf("%s %s: wanted %d, got %d %s", c.name, path, c.status, recorder.Code, recorder.Body)
+			}
+		}
+		if saved := len(cs.Get()) == 1; saved != (c.status == http.StatusCreated) {
+			t.Errorf("%s: wanted saved %t, got %+v", c.name, c.status == http.StatusCreated, cs.Get())
+		}
+	}
+}
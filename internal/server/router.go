@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/markpotocki/health/pkg/handlers"
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// route is one method and path handled by a router. Segments of the pattern written as
+// "{name}" match any single path segment, read back with pathParam.
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	op       operation
+	handler  http.HandlerFunc
+}
+
+// unmatchedRoute is the name requests matching no route are timed under, so unknown paths
+// do not each keep their own latency.
+const unmatchedRoute = "/aidi/v1/{unmatched}"
+
+// router dispatches requests by method and path, answering unknown paths and methods with
+// the error body of the versioned api. Each request is timed under the pattern of the route
+// it matched.
+type router struct {
+	routes []*route
+}
+
+type pathParamsKey struct{}
+
+func (rt *router) handle(method, pattern string, op operation, handler http.HandlerFunc) {
+	rt.routes = append(rt.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+		op:       op,
+		handler:  handler,
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	allowed := []string{}
+	for _, rte := range rt.routes {
+		params, ok := rte.match(segments)
+		if !ok {
+			continue
+		}
+		if rte.method != r.Method {
+			allowed = append(allowed, rte.method)
+			continue
+		}
+		handlers.RouteTimer(rte.pattern, rte.handler).ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params)))
+		return
+	}
+	handlers.RouteTimer(unmatchedRoute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt.unmatched(w, r, allowed)
+	})).ServeHTTP(w, r)
+}
+
+// unmatched answers a request matching no route, with 405 when the path has routes for
+// other methods.
+func (rt *router) unmatched(w http.ResponseWriter, r *http.Request, allowed []string) {
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, models.APIError{Code: models.ErrCodeMethodNotAllowed, Message: r.Method + " is not allowed on " + r.URL.Path})
+		return
+	}
+	writeError(w, http.StatusNotFound, models.APIError{Code: models.ErrCodeNotFound, Message: "no such path " + r.URL.Path})
+}
+
+func (rte *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rte.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, segment := range rte.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = value
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// pathParam is the value of the "{name}" segment of the route that matched r.
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -19,9 +22,12 @@ import (
 
 const port = 9999
 
+// DefaultAddr is the address the server listens on unless changed with SetAddr.
+const DefaultAddr = ":9900"
+
 // ClientStore is an object that is able to hold records of ClientInfo. It is used as an
 // interface to allow for a database backed solution instead of the memory back one
-// provided.
+// provided. Clients are keyed by models.ClientInfo.ID, so each instance of a service is kept.
 type ClientStore interface {
 	Save(models.ClientInfo)
 	Get() []models.ClientInfo
@@ -29,63 +35,138 @@ type ClientStore interface {
 
 // StatusStore is an object that is able to hold records of ClientInfo. It is used as an
 // interface to allow for a database backed solution instead of the memory back one
-// provided.
+// provided. Statuses are keyed by HealthStatus.ID, so each instance of a service is kept.
 type StatusStore interface {
 	SaveAll(...HealthStatus)
 	Save(HealthStatus)
-	Find(ClientName string) (HealthStatus, error)
+	Find(ID string) (HealthStatus, error)
 	FindAll() []HealthStatus
 }
 
 // HealthStatus contains the data that will be saved into the StatusStore. Contains the
 // health data supplied by the client, the name of the client, and when it was last updated.
+// Instance, Origin, Labels and Metadata are copied from the client's ClientInfo. Maintenance
+// is the ID of the maintenance window the client was in when polled, in which case Data is
+// reported up with models.StatusMaintenance.
 type HealthStatus struct {
-	ClientName string
-	Data       models.HealthStatus
-	Updated    int64
+	ClientName  string
+	Instance    string `json:",omitempty"`
+	Data        models.HealthStatus
+	Updated     int64
+	Origin      string            `json:",omitempty"`
+	Labels      map[string]string `json:",omitempty"`
+	Metadata    map[string]string `json:",omitempty"`
+	Maintenance string            `json:",omitempty"`
 }
 
 // Server is an aidi server that is able to take in health data from clients that register
 // with it.
 type Server struct {
+	addr        string
+	authToken   string
+	cluster     *Cluster
+	federation  *Federation
+	discoverers []Discoverer
 	clientStore ClientStore
 	statusStore StatusStore
 	connections sync.Map
+	heartbeat   int64 // unix nano of the last poll loop tick
+	polling     int32 // set while a round of polling runs
+	readyChecks map[string]ReadinessCheck
+	readyMutex  sync.RWMutex
 }
 
 // MakeServer provides a new Server pointer with the provided ClientStore and StatusStore.
 func MakeServer(clientStore ClientStore, statusStore StatusStore) *Server {
-	return &Server{clientStore, statusStore, sync.Map{}}
+	return &Server{
+		addr:        DefaultAddr,
+		federation:  MakeFederation(),
+		clientStore: clientStore,
+		statusStore: statusStore,
+		readyChecks: make(map[string]ReadinessCheck),
+	}
+}
+
+// SetAddr changes the address the server listens on, which must be called before Start.
+func (srv *Server) SetAddr(addr string) {
+	srv.addr = addr
+}
+
+// JoinCluster makes the server a member of c, sharing registrations and polling with the
+// other members. It must be called before Start.
+func (srv *Server) JoinCluster(c *Cluster) {
+	srv.cluster = c
+}
+
+// AddUpstream federates a child aidi server, whose clients are pulled into the merged view
+// served on /aidi/federation.
+func (srv *Server) AddUpstream(up Upstream) error {
+	return srv.federation.Add(up)
 }
 
 // Start registers the servers handlers, starts up the http server, and registers with
 // itself. If all this is successful, it will run until shutdown, pinging clients at the
-// set interval of 10s.
+// set pollInterval.
 func (srv *Server) Start() {
 	log.Println("server: starting health server")
-	http.Handle("/aidi/register", handlers.ResponseTimer(http.HandlerFunc(srv.registerHandler)))
-	http.Handle("/aidi/ready", handlers.ResponseTimer(http.HandlerFunc(srv.readyHandler)))
+	srv.beat()
+	http.Handle("/aidi/register", handlers.RouteTimer("/aidi/register", http.HandlerFunc(srv.registerHandler)))
+	http.Handle("/aidi/ready", handlers.RouteTimer("/aidi/ready", http.HandlerFunc(srv.readyHandler)))
 	http.Handle("/aidi/health/", http.HandlerFunc(srv.clientInfoHandler))
+	http.Handle("/aidi/clients", http.HandlerFunc(srv.clientsHandler))
+	http.Handle("/aidi/services", http.HandlerFunc(srv.servicesHandler))
+	http.Handle("/aidi/services/", http.HandlerFunc(srv.servicesHandler))
+	http.Handle("/aidi/cluster", http.HandlerFunc(srv.clusterHandler))
+	http.Handle("/aidi/cluster/sync", http.HandlerFunc(srv.syncHandler))
+	http.Handle("/aidi/federation", http.HandlerFunc(srv.federationHandler))
+	http.Handle("/aidi/v1/", srv.apiV1())
 	log.Println("server: registering handlers")
 	errchan := make(chan error, 1)
 
 	go func() {
-		errchan <- http.ListenAndServe(":9900", nil)
+		errchan <- http.ListenAndServe(srv.addr, nil)
 	}()
 
 	log.Println("server: server started correctly")
 
-	// register client data with self
+	go srv.federation.Run(federationInterval)
+	if srv.cluster != nil {
+		go srv.cluster.Run(pollInterval)
+	}
+	if len(srv.discoverers) > 0 {
+		go srv.discover(discoveryInterval)
+	}
+
+	// register client data with self, served on the same port so several servers can run
+	// side by side
 	log.Println("server: registering health data with self")
+	host, selfPort, err := net.SplitHostPort(srv.addr)
+	if err != nil {
+		log.Fatalf("server: bad listen address %s -- %v", srv.addr, err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
 	selfInfo := client.ConnectionConfig{
-		Host: "localhost",
-		Port: "9900",
+		Host: host,
+		Port: selfPort,
+		Mux:  http.DefaultServeMux,
+	}
+	if srv.cluster != nil {
+		// every member is an instance of the aidi service
+		host, _, _ = net.SplitHostPort(srv.cluster.Self())
+		selfInfo.Host, selfInfo.Instance = host, srv.cluster.Self()
 	}
 
-	cli := client.MakeClient("aidi", 9901, selfInfo)
+	clientPort, _ := strconv.Atoi(selfPort)
+	cli := client.MakeClient("aidi", clientPort, selfInfo)
 	log.Println("server: self client created")
 
-	cli.Connect(context.Background()) // the error is being ignored
+	go func() {
+		if err := cli.Connect(context.Background()); err != nil {
+			log.Printf("server: could not register with self -- %v", err)
+		}
+	}()
 
 	// running
 	var resetCount int
@@ -103,10 +184,11 @@ func (srv *Server) Start() {
 			resetCount++
 			if resetCount < 3 {
 				go func() {
-					errchan <- http.ListenAndServe(":9900", nil)
+					errchan <- http.ListenAndServe(srv.addr, nil)
 				}()
 			}
-		case <-time.After(time.Duration(1 * time.Second)):
+		case <-time.After(pollInterval):
+			srv.beat()
 			log.Println("server: sending ping to all clients")
 			go srv.pingAll()
 		case <-sigQuit:
@@ -116,6 +198,11 @@ func (srv *Server) Start() {
 	}
 }
 
+// ID identifies the client the status is for, see models.ClientInfo.ID.
+func (hs HealthStatus) ID() string {
+	return models.InstanceID(hs.ClientName, hs.Instance)
+}
+
 func errorStatus(err error) models.HealthStatus {
 	return models.HealthStatus{
 		Down:   true,
@@ -123,18 +210,60 @@ func errorStatus(err error) models.HealthStatus {
 	}
 }
 
+// pingAll runs a round of polling. Clients are polled concurrently, at most
+// maxConcurrentPolls at once, and the round ends after roundTimeout with the clients not
+// reached reported down. A tick that comes while the last round still runs is skipped.
 func (srv *Server) pingAll() {
-	respchan := make(chan HealthStatus, 50)
+	if !atomic.CompareAndSwapInt32(&srv.polling, 0, 1) {
+		log.Println("server: last round of polling still running, skipping")
+		return
+	}
+	defer atomic.StoreInt32(&srv.polling, 0)
+
+	clients := srv.clientStore.Get()
+	if srv.cluster != nil {
+		clients = srv.cluster.Owned(clients)
+	}
+
+	now := time.Now()
+	windows := srv.currentMaintenance(now)
+
+	ctx, cancel := context.WithTimeout(context.Background(), roundTimeout)
+	defer cancel()
+	respchan := make(chan HealthStatus, len(clients))
+	slots := make(chan struct{}, maxConcurrentPolls)
+	wg := sync.WaitGroup{}
+	for _, cli := range clients {
+		wg.Add(1)
+		go func(cli models.ClientInfo) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				send(ctx, cli, respchan)
+				<-slots
+			case <-ctx.Done():
+				respchan <- newStatus(cli, errorStatus(fmt.Errorf("not polled within the round -- %v", ctx.Err())))
+			}
+		}(cli)
+	}
 	go func() {
-		for _, cli := range srv.clientStore.Get() {
-			send(cli, respchan)
-		}
+		wg.Wait()
 		close(respchan)
 	}()
 
+	polled := make([]HealthStatus, 0, len(clients))
 	for resp := range respchan {
+		if resp.Maintenance = maintenanceFor(windows, resp, now); resp.Maintenance != "" {
+			resp.Data.Down, resp.Data.Status = false, models.StatusMaintenance
+		}
 		log.Printf("server: saving to db %v", resp)
 		srv.statusStore.Save(resp)
+		polled = append(polled, resp)
+	}
+
+	// share what we know, which also tells the other members we are alive
+	if srv.cluster != nil {
+		go srv.cluster.push(syncMessage{Clients: srv.clientStore.Get(), Statuses: polled, Maintenance: windows})
 	}
 
 	// we saved it all, notify there is new data
@@ -142,7 +271,10 @@ func (srv *Server) pingAll() {
 		srv.connections.Range(func(key interface{}, val interface{}) bool {
 			log.Printf("DEBUG: sending to %v", key)
 			if ch, ok := val.(chan struct{}); ok {
-				ch <- struct{}{}
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
 			}
 			return true
 		})
@@ -154,25 +286,54 @@ var httpcli = http.Client{
 	Timeout: time.Duration(7 * time.Second),
 }
 
-func send(cli models.ClientInfo, respchan chan<- HealthStatus) {
-	resp, err := httpcli.Get(cli.URL())
+// maxConcurrentPolls bounds how many clients are polled at once.
+const maxConcurrentPolls = 32
+
+// roundTimeout bounds a round of polling, so one slow client cannot hold up the others.
+const roundTimeout = 10 * time.Second
+
+func send(ctx context.Context, cli models.ClientInfo, respchan chan<- HealthStatus) {
+	respchan <- newStatus(cli, poll(ctx, cli))
+}
+
+// newStatus is the HealthStatus of cli polled now.
+func newStatus(cli models.ClientInfo, data models.HealthStatus) HealthStatus {
+	return HealthStatus{
+		ClientName: cli.Name(),
+		Instance:   cli.Instance,
+		Data:       data,
+		Updated:    time.Now().Unix(),
+		Origin:     cli.Origin,
+		Labels:     cli.Labels,
+		Metadata:   cli.Metadata,
+	}
+}
+
+// poll fetches the client's HealthStatus, or for probe targets runs the probe.
+func poll(ctx context.Context, cli models.ClientInfo) models.HealthStatus {
+	if cli.Probe != nil {
+		return runProbe(ctx, *cli.Probe)
+	}
+
+	req, err := http.NewRequest("GET", cli.URL(), nil)
 	if err != nil {
-		respchan <- HealthStatus{cli.Name(), errorStatus(err), time.Now().Unix()}
-		return
+		return errorStatus(err)
 	}
+	resp, err := httpcli.Do(req.WithContext(ctx))
+	if err != nil {
+		return errorStatus(err)
+	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		log.Printf("server: tried to reach %s but got bad status", cli.URL())
-		respchan <- HealthStatus{cli.Name(), errorStatus(fmt.Errorf("did not get 200 response, got %d", resp.StatusCode)), time.Now().Unix()}
-		return
+		return errorStatus(fmt.Errorf("did not get 200 response, got %d", resp.StatusCode))
 	}
 
 	hs := models.HealthStatus{}
 	err = json.NewDecoder(resp.Body).Decode(&hs)
 	if err != nil {
-		respchan <- HealthStatus{cli.Name(), errorStatus(err), time.Now().Unix()}
-		return
+		return errorStatus(err)
 	}
-
-	respchan <- HealthStatus{cli.Name(), hs, time.Now().Unix()}
+	return hs
 }
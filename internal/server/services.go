@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ServiceSummary aggregates the instances of one service. Instances in a maintenance window
+// are counted by Maintenance rather than Up or Down. CPU and Memory are averaged over
+// the instances that are up and report process stats, which leaves out probe targets;
+// CPU is utilization in percent and Memory the process memory in use.
+type ServiceSummary struct {
+	Name        string            `json:"name"`
+	Instances   int               `json:"instances"`
+	Up          int               `json:"up"`
+	Down        int               `json:"down"`
+	Maintenance int               `json:"maintenance"`
+	CPU         float64           `json:"cpu"`
+	Memory      float64           `json:"memory"`
+	Updated     int64             `json:"updated"`
+	Members     []InstanceSummary `json:"members"`
+}
+
+// InstanceSummary is the state of one instance within a ServiceSummary. Maintenance is the
+// ID of the maintenance window it is in.
+type InstanceSummary struct {
+	Instance    string `json:"instance"`
+	Down        bool   `json:"down"`
+	Status      string `json:"status"`
+	Updated     int64  `json:"updated"`
+	Maintenance string `json:"maintenance,omitempty"`
+}
+
+// summarize groups statuses by service name, sorted by name with members sorted by instance.
+func summarize(statuses []HealthStatus) []ServiceSummary {
+	byName := make(map[string]*ServiceSummary)
+	reporting := make(map[string]int)
+	for _, hs := range statuses {
+		summary, ok := byName[hs.ClientName]
+		if !ok {
+			summary = &ServiceSummary{Name: hs.ClientName, Members: []InstanceSummary{}}
+			byName[hs.ClientName] = summary
+		}
+		summary.Instances++
+		if hs.Maintenance != "" {
+			summary.Maintenance++
+		} else if hs.Data.Down {
+			summary.Down++
+		} else {
+			summary.Up++
+			if hs.Data.Probe == nil {
+				summary.CPU += float64(hs.Data.CPU.Utilization)
+				summary.Memory += float64(hs.Data.Memory.ProcUsed)
+				reporting[hs.ClientName]++
+			}
+		}
+		if hs.Updated > summary.Updated {
+			summary.Updated = hs.Updated
+		}
+		summary.Members = append(summary.Members, InstanceSummary{
+			Instance:    hs.Instance,
+			Down:        hs.Data.Down,
+			Status:      hs.Data.Status,
+			Updated:     hs.Updated,
+			Maintenance: hs.Maintenance,
+		})
+	}
+
+	ret := make([]ServiceSummary, 0, len(byName))
+	for name, summary := range byName {
+		if n := reporting[name]; n > 0 {
+			summary.CPU /= float64(n)
+			summary.Memory /= float64(n)
+		}
+		sort.Slice(summary.Members, func(i, j int) bool { return summary.Members[i].Instance < summary.Members[j].Instance })
+		ret = append(ret, *summary)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// servicesHandler serves the summary of every service on /aidi/services, narrowed by
+// ?selector= when given, and of a single service on /aidi/services/<name>.
+func (srv *Server) servicesHandler(w http.ResponseWriter, r *http.Request) {
+	sel, ok := selector(w, r)
+	if !ok {
+		return
+	}
+	summaries := summarize(srv.selectStatuses(sel))
+
+	var body interface{} = summaries
+	if name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/aidi/services"), "/"); name != "" {
+		body = nil
+		for _, summary := range summaries {
+			if summary.Name == name {
+				body = summary
+			}
+		}
+		if body == nil {
+			http.Error(w, "could not find the requested service", http.StatusNotFound)
+			return
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("server: encountered error encoding json: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestInstancesKeptApart(t *testing.T) {
+	cs := &memClientStore{}
+	srv := MakeServer(cs, &memStatusStore{})
+
+	// two replicas without an instance id, and one naming itself
+	for _, remote := range []string{"10.0.0.1:41000", "10.0.0.2:41000"} {
+		body, _ := json.Marshal(models.ClientInfo{CName: "orders", CPort: 8080})
+		request := httptest.NewRequest("POST", "/aidi/register", bytes.NewReader(body))
+		request.RemoteAddr = remote
+		srv.registerHandler(httptest.NewRecorder(), request)
+	}
+	body, _ := json.Marshal(models.ClientInfo{CName: "orders", CPort: 8080, Instance: "orders-7d9f"})
+	srv.registerHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/aidi/register", bytes.NewReader(body)))
+
+	ids := []string{}
+	for _, info := range cs.Get() {
+		ids = append(ids, info.ID())
+	}
+	expect := []string{"orders@10.0.0.1:8080", "orders@10.0.0.2:8080", "orders@orders-7d9f"}
+	if len(ids) != len(expect) {
+		t.Fatalf("wanted %v, got %v", expect, ids)
+	}
+	for i := range expect {
+		if ids[i] != expect[i] {
+			t.Errorf("wanted %v, got %v", expect, ids)
+		}
+	}
+}
+
+func TestServicesHandler(t *testing.T) {
+	ss := &memStatusStore{}
+	srv := MakeServer(&memClientStore{}, ss)
+	up := func(cpu uint) models.HealthStatus {
+		return models.HealthStatus{CPU: models.HealthStatusCpu{Utilization: cpu}, Memory: models.HealthStatusMem{ProcUsed: 100}}
+	}
+	ss.SaveAll(
+		HealthStatus{ClientName: "orders", Instance: "b", Data: up(20), Updated: 5},
+		HealthStatus{ClientName: "orders", Instance: "a", Data: up(40), Updated: 7},
+		HealthStatus{ClientName: "orders", Instance: "c", Data: models.HealthStatus{Down: true, Status: "down: database"}, Updated: 6},
+		HealthStatus{ClientName: "site", Data: models.HealthStatus{Status: "ok", Probe: &models.ProbeResult{Kind: models.ProbeHTTP}}},
+	)
+
+	recorder := httptest.NewRecorder()
+	srv.servicesHandler(recorder, httptest.NewRequest("GET", "/aidi/services", nil))
+	summaries := []ServiceSummary{}
+	if err := json.NewDecoder(recorder.Body).Decode(&summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries[0].Name != "orders" || summaries[1].Name != "site" {
+		t.Fatalf("wanted orders and site, got %+v", summaries)
+	}
+	orders := summaries[0]
+	if orders.Instances != 3 || orders.Up != 2 || orders.Down != 1 || orders.CPU != 30 || orders.Memory != 100 || orders.Updated != 7 {
+		t.Errorf("unexpected orders summary %+v", orders)
+	}
+	if orders.Members[0].Instance != "a" || !orders.Members[2].Down {
+		t.Errorf("wanted members sorted by instance, got %+v", orders.Members)
+	}
+	if site := summaries[1]; site.Up != 1 || site.CPU != 0 {
+		t.Errorf("wanted probe counted up without stats, got %+v", site)
+	}
+
+	recorder = httptest.NewRecorder()
+	srv.servicesHandler(recorder, httptest.NewRequest("GET", "/aidi/services/orders", nil))
+	summary := ServiceSummary{}
+	json.NewDecoder(recorder.Body).Decode(&summary)
+	if recorder.Code != http.StatusOK || summary.Instances != 3 {
+		t.Errorf("wanted orders summary, got %d %+v", recorder.Code, summary)
+	}
+
+	recorder = httptest.NewRecorder()
+	srv.servicesHandler(recorder, httptest.NewRequest("GET", "/aidi/services/missing", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("wanted 404 for unknown service, got %d", recorder.Code)
+	}
+}
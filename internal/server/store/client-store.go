@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"log"
 	"sync"
 
@@ -8,33 +9,72 @@ import (
 )
 
 type ClientStore struct {
-	db    []models.ClientInfo
-	mutex sync.Mutex
+	db          []models.ClientInfo
+	index       labelIndex
+	maintenance map[string]models.Maintenance
+	mutex       sync.Mutex
 }
 
 func MakeClientStore() *ClientStore {
 	return &ClientStore{
-		db:    make([]models.ClientInfo, 0),
-		mutex: sync.Mutex{},
+		db:          make([]models.ClientInfo, 0),
+		index:       makeLabelIndex(),
+		maintenance: make(map[string]models.Maintenance),
+		mutex:       sync.Mutex{},
 	}
 }
 
 func (cs *ClientStore) Save(info models.ClientInfo) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	cs.index.set(info.ID(), info.Labels)
 	for i, cinfo := range cs.db {
-		if info.Name() == cinfo.Name() {
-			log.Printf("clientstore: match found on %s, updating entry", info.Name())
-			cs.mutex.Lock()
+		if info.ID() == cinfo.ID() {
+			log.Printf("clientstore: match found on %s, updating entry", info.ID())
 			cs.db[i] = info
-			cs.mutex.Unlock()
 			return
 		}
 	}
-	log.Printf("clientstore: adding new entry %s", info.Name())
-	cs.mutex.Lock()
+	log.Printf("clientstore: adding new entry %s", info.ID())
 	cs.db = append(cs.db, info)
-	cs.mutex.Unlock()
 }
 
+// Get returns a copy of every client.
 func (cs *ClientStore) Get() []models.ClientInfo {
-	return cs.db
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return append(make([]models.ClientInfo, 0, len(cs.db)), cs.db...)
+}
+
+// Select returns the clients whose labels match sel.
+func (cs *ClientStore) Select(sel models.Selector) []models.ClientInfo {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	names, all := cs.index.candidates(sel)
+	ret := make([]models.ClientInfo, 0)
+	for _, info := range cs.db {
+		if (all || names[info.ID()]) && sel.Matches(info.Labels) {
+			ret = append(ret, info)
+		}
+	}
+	return ret
+}
+
+// Delete removes the client with the given ID, if there is one.
+func (cs *ClientStore) Delete(id string) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	for i, cinfo := range cs.db {
+		if cinfo.ID() == id {
+			log.Printf("clientstore: removing entry %s", id)
+			cs.db = append(cs.db[:i], cs.db[i+1:]...)
+			cs.index.remove(id)
+			return
+		}
+	}
+}
+
+// Ping always succeeds as the store is held in memory.
+func (cs *ClientStore) Ping(ctx context.Context) error {
+	return nil
 }
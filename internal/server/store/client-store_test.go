@@ -0,0 +1,51 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/markpotocki/health/internal/server"
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// TestStoresConcurrent is meant for go test -race: registration, deregistration and polling
+// all touch the stores at once.
+func TestStoresConcurrent(t *testing.T) {
+	cs, ss := MakeClientStore(), MakeStatusStore()
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				name := fmt.Sprintf("client-%d", i%10)
+				cs.Save(models.ClientInfo{CName: name})
+				ss.Save(server.HealthStatus{ClientName: name})
+				for _, info := range cs.Get() {
+					info.ID()
+				}
+				ss.Find(name)
+				ss.FindAll()
+				cs.Delete(name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the same ID is never stored twice
+	seen := make(map[string]bool)
+	for _, info := range cs.Get() {
+		if seen[info.ID()] {
+			t.Errorf("%s stored twice", info.ID())
+		}
+		seen[info.ID()] = true
+	}
+	seen = make(map[string]bool)
+	for _, hs := range ss.FindAll() {
+		if seen[hs.ID()] {
+			t.Errorf("status of %s stored twice", hs.ID())
+		}
+		seen[hs.ID()] = true
+	}
+}
@@ -0,0 +1,73 @@
+package store
+
+import "github.com/markpotocki/health/pkg/models"
+
+// labelIndex maps every label key and value to the names of the entries carrying it, so a
+// selector with equality requirements only looks at the entries that can match.
+type labelIndex struct {
+	entries map[string]map[string]map[string]bool // key -> value -> names
+	labels  map[string]map[string]string          // name -> labels
+}
+
+func makeLabelIndex() labelIndex {
+	return labelIndex{
+		entries: make(map[string]map[string]map[string]bool),
+		labels:  make(map[string]map[string]string),
+	}
+}
+
+// set replaces the labels indexed for name.
+func (li labelIndex) set(name string, labels map[string]string) {
+	li.remove(name)
+	for key, value := range labels {
+		values, ok := li.entries[key]
+		if !ok {
+			values = make(map[string]map[string]bool)
+			li.entries[key] = values
+		}
+		if values[value] == nil {
+			values[value] = make(map[string]bool)
+		}
+		values[value][name] = true
+	}
+	li.labels[name] = labels
+}
+
+func (li labelIndex) remove(name string) {
+	for key, value := range li.labels[name] {
+		delete(li.entries[key][value], name)
+		if len(li.entries[key][value]) == 0 {
+			delete(li.entries[key], value)
+		}
+		if len(li.entries[key]) == 0 {
+			delete(li.entries, key)
+		}
+	}
+	delete(li.labels, name)
+}
+
+// candidates narrows down the names that can match sel using its equality requirements.
+// When sel has none it reports all and every entry has to be checked.
+func (li labelIndex) candidates(sel models.Selector) (names map[string]bool, all bool) {
+	all = true
+	for _, req := range sel {
+		// an empty value also matches entries without the label, which are not indexed
+		if req.Operator != models.SelectEquals || req.Value == "" {
+			continue
+		}
+		matching := li.entries[req.Key][req.Value]
+		if all {
+			names, all = make(map[string]bool, len(matching)), false
+			for name := range matching {
+				names[name] = true
+			}
+			continue
+		}
+		for name := range names {
+			if !matching[name] {
+				delete(names, name)
+			}
+		}
+	}
+	return names, all
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestClientStoreSelect(t *testing.T) {
+	cs := MakeClientStore()
+	cs.Save(models.ClientInfo{CName: "orders", Labels: map[string]string{"env": "prod", "team": "orders"}})
+	cs.Save(models.ClientInfo{CName: "payments", Labels: map[string]string{"env": "prod", "team": "payments"}})
+	cs.Save(models.ClientInfo{CName: "staging", Labels: map[string]string{"env": "staging"}})
+	cs.Save(models.ClientInfo{CName: "legacy"})
+
+	var testCases = []struct {
+		selector string
+		expect   []string
+	}{
+		{"", []string{"orders", "payments", "staging", "legacy"}},
+		{"env=prod", []string{"orders", "payments"}},
+		{"env=prod,team!=payments", []string{"orders"}},
+		{"env=prod,team=orders", []string{"orders"}},
+		{"env!=prod", []string{"staging", "legacy"}},
+		{"!env", []string{"legacy"}},
+		{"env=dev", []string{}},
+	}
+	for _, test := range testCases {
+		sel, err := models.ParseSelector(test.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := cs.Select(sel)
+		if len(got) != len(test.expect) {
+			t.Errorf("%q: wanted %v, got %v", test.selector, test.expect, got)
+			continue
+		}
+		for i := range got {
+			if got[i].Name() != test.expect[i] {
+				t.Errorf("%q: wanted %v, got %v", test.selector, test.expect, got)
+				break
+			}
+		}
+	}
+
+	// relabelled and removed clients leave the index
+	cs.Save(models.ClientInfo{CName: "orders", Labels: map[string]string{"env": "staging"}})
+	cs.Delete("payments")
+	sel, _ := models.ParseSelector("env=prod")
+	if got := cs.Select(sel); len(got) != 0 {
+		t.Errorf("wanted no prod clients left, got %v", got)
+	}
+	if len(cs.index.entries["env"]["prod"]) != 0 || len(cs.index.entries["team"]) != 0 {
+		t.Errorf("wanted stale index entries removed, got %v", cs.index.entries)
+	}
+}
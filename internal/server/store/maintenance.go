@@ -0,0 +1,46 @@
+package store
+
+import (
+	"log"
+	"sort"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// SaveMaintenance adds or replaces a maintenance window.
+func (cs *ClientStore) SaveMaintenance(m models.Maintenance) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	log.Printf("clientstore: saving maintenance window %s", m.ID)
+	cs.maintenance[m.ID] = m
+}
+
+// DeleteMaintenance removes the maintenance window with the given ID, reporting whether
+// there was one.
+func (cs *ClientStore) DeleteMaintenance(id string) bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if _, ok := cs.maintenance[id]; !ok {
+		return false
+	}
+	log.Printf("clientstore: removing maintenance window %s", id)
+	delete(cs.maintenance, id)
+	return true
+}
+
+// Maintenance returns every maintenance window, ordered by start.
+func (cs *ClientStore) Maintenance() []models.Maintenance {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	ret := make([]models.Maintenance, 0, len(cs.maintenance))
+	for _, m := range cs.maintenance {
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Start.Equal(ret[j].Start) {
+			return ret[i].ID < ret[j].ID
+		}
+		return ret[i].Start.Before(ret[j].Start)
+	})
+	return ret
+}
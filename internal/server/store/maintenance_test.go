@@ -0,0 +1,24 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestClientStoreMaintenance(t *testing.T) {
+	cs := MakeClientStore()
+	now := time.Now()
+	cs.SaveMaintenance(models.Maintenance{ID: "b", Start: now.Add(time.Hour)})
+	cs.SaveMaintenance(models.Maintenance{ID: "a", Start: now})
+	cs.SaveMaintenance(models.Maintenance{ID: "b", Start: now.Add(-time.Hour)})
+
+	windows := cs.Maintenance()
+	if len(windows) != 2 || windows[0].ID != "b" || windows[1].ID != "a" {
+		t.Errorf("wanted replaced window first by start, got %+v", windows)
+	}
+	if !cs.DeleteMaintenance("a") || cs.DeleteMaintenance("a") || len(cs.Maintenance()) != 1 {
+		t.Errorf("wanted window deleted once, got %+v", cs.Maintenance())
+	}
+}
@@ -1,11 +1,13 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"log"
 	"sync"
 
 	"github.com/markpotocki/health/internal/server"
+	"github.com/markpotocki/health/pkg/models"
 )
 
 // ErrNotFound is returned when the value is not found
@@ -13,30 +15,31 @@ type ErrNotFound error
 
 type StatusStore struct {
 	db    []server.HealthStatus
+	index labelIndex
 	mutex sync.Mutex
 }
 
 func MakeStatusStore() *StatusStore {
 	return &StatusStore{
 		db:    make([]server.HealthStatus, 0),
+		index: makeLabelIndex(),
 		mutex: sync.Mutex{},
 	}
 }
 
 func (ss *StatusStore) Save(hs server.HealthStatus) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	ss.index.set(hs.ID(), hs.Labels)
 	for i, sshs := range ss.db {
-		if sshs.ClientName == hs.ClientName {
-			log.Printf("statusstore: match found for %s, updating", sshs.ClientName)
-			ss.mutex.Lock()
+		if sshs.ID() == hs.ID() {
+			log.Printf("statusstore: match found for %s, updating", sshs.ID())
 			ss.db[i] = hs
-			ss.mutex.Unlock()
 			return
 		}
 	}
-	log.Printf("statusstore: adding new entry for %s", hs.ClientName)
-	ss.mutex.Lock()
+	log.Printf("statusstore: adding new entry for %s", hs.ID())
 	ss.db = append(ss.db, hs)
-	ss.mutex.Unlock()
 }
 
 func (ss *StatusStore) SaveAll(hss ...server.HealthStatus) {
@@ -46,14 +49,38 @@ func (ss *StatusStore) SaveAll(hss ...server.HealthStatus) {
 }
 
 func (ss *StatusStore) Find(name string) (server.HealthStatus, error) { // might need to return an error here
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
 	for _, hs := range ss.db {
-		if hs.ClientName == name {
+		if hs.ID() == name {
 			return hs, nil
 		}
 	}
 	return server.HealthStatus{}, ErrNotFound(errors.New("value " + name + " not found"))
 }
 
+// FindAll returns a copy of every status.
 func (ss *StatusStore) FindAll() []server.HealthStatus {
-	return ss.db
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	return append(make([]server.HealthStatus, 0, len(ss.db)), ss.db...)
+}
+
+// FindSelected returns the statuses whose labels match sel.
+func (ss *StatusStore) FindSelected(sel models.Selector) []server.HealthStatus {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	names, all := ss.index.candidates(sel)
+	ret := make([]server.HealthStatus, 0)
+	for _, hs := range ss.db {
+		if (all || names[hs.ID()]) && sel.Matches(hs.Labels) {
+			ret = append(ret, hs)
+		}
+	}
+	return ret
+}
+
+// Ping always succeeds as the store is held in memory.
+func (ss *StatusStore) Ping(ctx context.Context) error {
+	return nil
 }
@@ -0,0 +1,283 @@
+package status
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unlimitedMemory is the smallest value cgroup v1 reports in memory.limit_in_bytes when no
+// limit is set. The exact value is PAGE_COUNTER_MAX rounded to the page size, so anything at
+// or above this is treated as unlimited.
+const unlimitedMemory = 1 << 62
+
+// ErrNoCgroup is returned when no cgroup filesystem could be found.
+var ErrNoCgroup = errors.New("status: no cgroup filesystem found")
+
+// CgroupStats holds the limits and usage of the cgroup the process is running in. CPUQuota
+// is in cores and MemoryLimit in bytes, zero meaning no limit is set. CPUUsage is a
+// percentage of the quota (or of every host core when there is no quota) used since the
+// last sample.
+type CgroupStats struct {
+	Version          int
+	CPUQuota         float64
+	CPUUsage         float64
+	Periods          uint64
+	ThrottledPeriods uint64
+	ThrottledTime    time.Duration
+	MemoryLimit      uint64
+	MemoryUsage      uint64
+}
+
+// Limited reports whether the cgroup places a cpu or memory limit on the process. When it
+// does not, the host numbers are the better description of the resources available.
+func (cs CgroupStats) Limited() bool {
+	return cs.CPUQuota > 0 || cs.MemoryLimit > 0
+}
+
+// CgroupSampler reads cgroup v1 or v2 accounting for the current process. The version is
+// detected on every sample so a sampler can be created before the filesystem is mounted.
+type CgroupSampler struct {
+	root      string
+	procPath  string
+	prevUsage time.Duration
+	prevTime  time.Time
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+// GlobalCgroupSampler is the sampler for the process this package is running in.
+var GlobalCgroupSampler = MakeCgroupSampler("/sys/fs/cgroup", "/proc")
+
+// MakeCgroupSampler provides a CgroupSampler for the cgroup hierarchy mounted at root, using
+// procPath/self/cgroup to find which cgroup the process belongs to.
+func MakeCgroupSampler(root, procPath string) *CgroupSampler {
+	return &CgroupSampler{
+		root:     root,
+		procPath: procPath,
+		now:      time.Now,
+	}
+}
+
+// Sample reads the current limits and usage. ErrNoCgroup is returned when root is not a
+// cgroup filesystem.
+func (cs *CgroupSampler) Sample() (CgroupStats, error) {
+	paths, err := cs.readSelfCgroup()
+	if err != nil {
+		return CgroupStats{}, err
+	}
+
+	var stats CgroupStats
+	var usage time.Duration
+	if exists(filepath.Join(cs.root, "cgroup.controllers")) {
+		stats, usage, err = cs.readV2(cs.dir("", paths[""]))
+	} else if exists(filepath.Join(cs.root, "memory")) || exists(filepath.Join(cs.root, "cpu")) {
+		stats, usage, err = cs.readV1(paths)
+	} else {
+		return CgroupStats{}, ErrNoCgroup
+	}
+	if err != nil {
+		return CgroupStats{}, err
+	}
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	now := cs.now()
+	if !cs.prevTime.IsZero() && usage >= cs.prevUsage {
+		limit := stats.CPUQuota
+		if limit == 0 {
+			limit = float64(runtime.NumCPU())
+		}
+		wall := now.Sub(cs.prevTime).Seconds() * limit
+		if wall > 0 {
+			stats.CPUUsage = (usage - cs.prevUsage).Seconds() / wall * 100
+		}
+	}
+	cs.prevUsage, cs.prevTime = usage, now
+
+	return stats, nil
+}
+
+// readSelfCgroup maps each v1 controller (or "" for the v2 unified hierarchy) to the path of
+// the cgroup the process is in.
+func (cs *CgroupSampler) readSelfCgroup() (map[string]string, error) {
+	ret := make(map[string]string)
+	fil, err := os.Open(filepath.Join(cs.procPath, "self", "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer fil.Close()
+
+	scanner := bufio.NewScanner(fil)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			ret[controller] = parts[2]
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// dir finds the directory for a controller. Inside a container with its own cgroup namespace
+// the path in /proc/self/cgroup may not exist under the mount, in which case the mount itself
+// is the process's cgroup.
+func (cs *CgroupSampler) dir(controller, path string) string {
+	base := filepath.Join(cs.root, controller)
+	if full := filepath.Join(base, path); path != "" && exists(full) {
+		return full
+	}
+	return base
+}
+
+func (cs *CgroupSampler) readV2(dir string) (CgroupStats, time.Duration, error) {
+	stats := CgroupStats{Version: 2}
+
+	if max, err := readString(filepath.Join(dir, "cpu.max")); err == nil {
+		// "$MAX $PERIOD" where $MAX may be "max"
+		fields := strings.Fields(max)
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, qerr := strconv.ParseFloat(fields[0], 64)
+			period, perr := strconv.ParseFloat(fields[1], 64)
+			if qerr == nil && perr == nil && period > 0 {
+				stats.CPUQuota = quota / period
+			}
+		}
+	}
+
+	cpuStat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return CgroupStats{}, 0, err
+	}
+	stats.Periods = cpuStat["nr_periods"]
+	stats.ThrottledPeriods = cpuStat["nr_throttled"]
+	stats.ThrottledTime = time.Duration(cpuStat["throttled_usec"]) * time.Microsecond
+	usage := time.Duration(cpuStat["usage_usec"]) * time.Microsecond
+
+	if max, err := readString(filepath.Join(dir, "memory.max")); err == nil && max != "max" {
+		if stats.MemoryLimit, err = strconv.ParseUint(max, 10, 64); err != nil {
+			return CgroupStats{}, 0, err
+		}
+	}
+	if stats.MemoryUsage, err = readUint(filepath.Join(dir, "memory.current")); err != nil && !os.IsNotExist(err) {
+		return CgroupStats{}, 0, err
+	}
+
+	return stats, usage, nil
+}
+
+func (cs *CgroupSampler) readV1(paths map[string]string) (CgroupStats, time.Duration, error) {
+	stats := CgroupStats{Version: 1}
+
+	cpuDir := cs.dir("cpu", paths["cpu"])
+	quota, qerr := readInt(filepath.Join(cpuDir, "cpu.cfs_quota_us"))
+	period, perr := readInt(filepath.Join(cpuDir, "cpu.cfs_period_us"))
+	if qerr == nil && perr == nil && quota > 0 && period > 0 {
+		stats.CPUQuota = float64(quota) / float64(period)
+	}
+
+	if cpuStat, err := readKeyValues(filepath.Join(cpuDir, "cpu.stat")); err == nil {
+		stats.Periods = cpuStat["nr_periods"]
+		stats.ThrottledPeriods = cpuStat["nr_throttled"]
+		stats.ThrottledTime = time.Duration(cpuStat["throttled_time"]) // nanoseconds
+	}
+
+	var usage time.Duration
+	acctDir := cs.dir("cpuacct", paths["cpuacct"])
+	if ns, err := readUint(filepath.Join(acctDir, "cpuacct.usage")); err == nil {
+		usage = time.Duration(ns)
+	}
+
+	memDir := cs.dir("memory", paths["memory"])
+	limit, err := readUint(filepath.Join(memDir, "memory.limit_in_bytes"))
+	if err != nil && !os.IsNotExist(err) {
+		return CgroupStats{}, 0, err
+	}
+	if limit < unlimitedMemory {
+		stats.MemoryLimit = limit
+	}
+	if stats.MemoryUsage, err = readUint(filepath.Join(memDir, "memory.usage_in_bytes")); err != nil && !os.IsNotExist(err) {
+		return CgroupStats{}, 0, err
+	}
+
+	return stats, usage, nil
+}
+
+// MemInfo holds the host memory totals from /proc/meminfo, in bytes.
+type MemInfo struct {
+	Total     uint64
+	Available uint64
+}
+
+// ReadMemInfo reads the host memory totals from the proc filesystem mounted at procPath.
+func ReadMemInfo(procPath string) (MemInfo, error) {
+	vals, err := readKeyValues(filepath.Join(procPath, "meminfo"))
+	if err != nil {
+		return MemInfo{}, err
+	}
+	// values are in kB
+	return MemInfo{
+		Total:     vals["MemTotal:"] * 1024,
+		Available: vals["MemAvailable:"] * 1024,
+	}, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readString(path string) (string, error) {
+	fil, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(fil)), nil
+}
+
+func readUint(path string) (uint64, error) {
+	str, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(str, 10, 64)
+}
+
+func readInt(path string) (int64, error) {
+	str, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+// readKeyValues reads files made of "key value" lines such as cpu.stat and meminfo. Lines
+// that do not parse are skipped.
+func readKeyValues(path string) (map[string]uint64, error) {
+	str, err := readString(path)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]uint64)
+	for _, line := range strings.Split(str, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if val, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			ret[fields[0]] = val
+		}
+	}
+	return ret, nil
+}
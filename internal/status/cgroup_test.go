@@ -0,0 +1,76 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCgroupV2(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	cs := MakeCgroupSampler("testdata/cgroup/v2/sys", "testdata/cgroup/v2/proc")
+	cs.now = func() time.Time { return clock }
+
+	// pretend 0.75s of cpu was used over the last second
+	cs.prevTime = clock.Add(-time.Second)
+	cs.prevUsage = 5*time.Second - 750*time.Millisecond
+
+	stats, err := cs.Sample()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expect := CgroupStats{
+		Version:          2,
+		CPUQuota:         1.5,
+		CPUUsage:         50,
+		Periods:          120,
+		ThrottledPeriods: 30,
+		ThrottledTime:    2500 * time.Millisecond,
+		MemoryLimit:      512 << 20,
+		MemoryUsage:      100 << 20,
+	}
+	if stats != expect {
+		t.Errorf("wanted %+v, got %+v", expect, stats)
+	}
+	if !stats.Limited() {
+		t.Error("expected v2 fixture to be limited")
+	}
+}
+
+func TestCgroupV1(t *testing.T) {
+	cs := MakeCgroupSampler("testdata/cgroup/v1/sys", "testdata/cgroup/v1/proc")
+	stats, err := cs.Sample()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// no quota and the "unlimited" memory sentinel, usage read from the namespaced root
+	expect := CgroupStats{
+		Version:     1,
+		MemoryUsage: 2 << 20,
+	}
+	if stats != expect {
+		t.Errorf("wanted %+v, got %+v", expect, stats)
+	}
+	if stats.Limited() {
+		t.Error("expected v1 fixture to be unlimited")
+	}
+}
+
+func TestNoCgroup(t *testing.T) {
+	cs := MakeCgroupSampler("testdata/cgroup/none/sys", "testdata/cgroup/none/proc")
+	if _, err := cs.Sample(); err != ErrNoCgroup {
+		t.Errorf("wanted ErrNoCgroup, got %v", err)
+	}
+}
+
+func TestReadMemInfo(t *testing.T) {
+	info, err := ReadMemInfo("testdata/process")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := MemInfo{Total: 8000000 * 1024, Available: 6000000 * 1024}
+	if info != expect {
+		t.Errorf("wanted %v, got %v", expect, info)
+	}
+}
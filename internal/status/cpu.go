@@ -1,128 +1,277 @@
 package status
 
 import (
-	"io/ioutil"
-	"log"
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
 	"path/filepath"
-	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // CPUUtilizationStats holds the usage information on each core of the CPU as well as the
-// total CPU usage. The information is represented as a percentage of utilization.
+// total CPU usage. The information is represented as a percentage of utilization, where
+// only time spent idle is not counted as utilized. States and CoreStates break the same
+// window down by where the time went, iowait included.
 type CPUUtilizationStats struct {
-	Total uint
-	Cores []uint
+	Total      uint
+	Cores      []uint
+	States     CPUStates
+	CoreStates []CPUStates
 }
 
-// CPUUtilization gets the percentage of cpu utilization from the computer it is running on.
-// It utilizes /proc/stat so as a consequence, this requires a linux OS to work correctly. The
-// return value is a channel to listen on for the result, or it will close if there is an error.
-func CPUUtilization() CPUUtilizationStats {
-	stats, err := getUtilization()
-	if err != nil {
-		panic(err)
-	}
-	return stats
+// CPUStates is the percentage of time spent in each state reported by /proc/stat.
+type CPUStates struct {
+	User    float64
+	Nice    float64
+	System  float64
+	Idle    float64
+	IOWait  float64
+	IRQ     float64
+	SoftIRQ float64
+	Steal   float64
+}
+
+// columns of a cpu line in /proc/stat, after the name. guest and guest_nice follow steal but
+// are already counted in user and nice so are left out of the total.
+const (
+	stateUser = iota
+	stateNice
+	stateSystem
+	stateIdle
+	stateIOWait
+	stateIRQ
+	stateSoftIRQ
+	stateSteal
+	numStates
+)
 
+// CPUUtilization gets the percentage of cpu utilization from the computer it is running on
+// using the GlobalCPUSampler. It utilizes /proc/stat so as a consequence, this requires a
+// linux OS to work correctly.
+func CPUUtilization() (CPUUtilizationStats, error) {
+	return GlobalCPUSampler.Utilization()
 }
 
+// utilStats holds the ticks of a cpu line. total sums every column and idle is the idle
+// column alone, which is what utilization has always been measured by; states breaks the
+// ticks down for the CPUStates.
 type utilStats struct {
-	total uint64
-	idle  uint64
+	total  uint64
+	idle   uint64
+	states [numStates]uint64
+}
+
+func (u utilStats) stateTotal() uint64 {
+	total := uint64(0)
+	for _, ticks := range u.states {
+		total += ticks
+	}
+	return total
 }
 
-var prev = struct {
+// CPUSampler turns the cumulative tick counters in /proc/stat into utilization percentages
+// by comparing against the previous reading. It is safe for concurrent use.
+//
+// Called directly, each Sample measures the window since the last one, so two callers
+// sampling at different rates see different numbers. Run instead samples on a fixed interval
+// in the background so every caller of Utilization sees the same, stable window.
+type CPUSampler struct {
+	procPath string
+	prev     cpuTimes
+	last     CPUUtilizationStats
+	hasLast  bool
+	running  int32
+	mutex    sync.Mutex
+}
+
+type cpuTimes struct {
 	total utilStats
 	cores []utilStats
-}{
-	utilStats{0, 0},
-	make([]utilStats, runtime.NumCPU()),
 }
 
-func getUtilization() (CPUUtilizationStats, error) {
-	// pass # 1
-	path, err := filepath.Abs("/proc/stat")
-	if err != nil {
-		return CPUUtilizationStats{}, err
+// GlobalCPUSampler is the sampler used when building the health status of this process.
+var GlobalCPUSampler = MakeCPUSampler("/proc")
+
+// MakeCPUSampler provides a CPUSampler that reads from the proc filesystem mounted at
+// procPath.
+func MakeCPUSampler(procPath string) *CPUSampler {
+	return &CPUSampler{
+		procPath: procPath,
 	}
-	fil, err := ioutil.ReadFile(path)
+}
+
+// Sample reads /proc/stat and returns the utilization since the previous sample, or since
+// boot on the first call. If no ticks have passed since the previous sample the previous
+// result is returned rather than dividing by zero.
+func (cs *CPUSampler) Sample() (CPUUtilizationStats, error) {
+	curr, err := cs.read()
 	if err != nil {
 		return CPUUtilizationStats{}, err
 	}
-	lines := strings.Split(string(fil), "\n")
-	tot, cores := calculate(lines)
-	defer func() {
-		prev.total = tot
-		prev.cores = cores
-	}()
 
-	ret := CPUUtilizationStats{}
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
 
-	total := tot.total - prev.total.total // sets the total
-	idle := tot.idle - prev.total.idle
-	ret.Total = uint(float64(total-idle) / float64(total) * 100)
+	if curr.total.total == cs.prev.total.total && cs.hasLast {
+		return cs.last, nil
+	}
 
-	for i, core := range cores {
-		total = core.total - prev.cores[i].total // sets the total
-		idle := core.idle - prev.cores[i].idle
-		ret.Cores = append(ret.Cores, uint(float64(total-idle)/float64(total)*100))
+	ret := CPUUtilizationStats{
+		Total:      percentBusy(cs.prev.total, curr.total),
+		Cores:      make([]uint, len(curr.cores)),
+		States:     percentStates(cs.prev.total, curr.total),
+		CoreStates: make([]CPUStates, len(curr.cores)),
+	}
+	for i, core := range curr.cores {
+		prev := utilStats{}
+		if i < len(cs.prev.cores) {
+			prev = cs.prev.cores[i]
+		}
+		ret.Cores[i] = percentBusy(prev, core)
+		ret.CoreStates[i] = percentStates(prev, core)
 	}
 
+	cs.prev = curr
+	cs.last, cs.hasLast = ret, true
 	return ret, nil
-
 }
 
-func calculate(lines []string) (total utilStats, cores []utilStats) {
-	//var total, idle uint64
-	cores = make([]utilStats, runtime.NumCPU())
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) < 1 {
-			continue
+// Utilization returns the last window measured by Run when the sampler is running in the
+// background, otherwise it takes a new Sample.
+func (cs *CPUSampler) Utilization() (CPUUtilizationStats, error) {
+	if atomic.LoadInt32(&cs.running) == 1 {
+		cs.mutex.Lock()
+		last, ok := cs.last, cs.hasLast
+		cs.mutex.Unlock()
+		if ok {
+			return last, nil
 		}
-		if match, err := regexp.MatchString("^(cpu){1}$", fields[0]); match {
-			if err != nil {
-				log.Printf("stats: encountered regex error -- %#v", err)
+	}
+	return cs.Sample()
+}
+
+// Run samples every interval until ctx is done. Only one Run per sampler does anything,
+// later calls return immediately.
+func (cs *CPUSampler) Run(ctx context.Context, interval time.Duration) {
+	if !atomic.CompareAndSwapInt32(&cs.running, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&cs.running, 0)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := cs.Sample(); err != nil {
+				// keep serving the last good window
 				continue
 			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-			for i := 1; i < len(fields); i++ {
-				conv, err := strconv.ParseUint(fields[i], 10, 64)
-				if err != nil {
-					log.Printf("stats: encountered error -- %#v", err)
-					continue
-				}
-				total.total += conv
-				if i == 4 {
-					total.idle = conv
-				}
-			}
+func percentBusy(prev, curr utilStats) uint {
+	if curr.total <= prev.total || curr.idle < prev.idle {
+		return 0
+	}
+	total := curr.total - prev.total
+	idle := curr.idle - prev.idle
+	if idle > total {
+		return 0
+	}
+	return uint(float64(total-idle) / float64(total) * 100)
+}
 
-		} else if match, err := regexp.MatchString("^(cpu\\d+){1}$", fields[0]); match {
+func percentStates(prev, curr utilStats) CPUStates {
+	if curr.stateTotal() <= prev.stateTotal() {
+		return CPUStates{}
+	}
+	total := float64(curr.stateTotal() - prev.stateTotal())
+	pct := func(state int) float64 {
+		if curr.states[state] < prev.states[state] {
+			return 0
+		}
+		diff := float64(curr.states[state] - prev.states[state])
+		return math.Round(diff/total*10000) / 100
+	}
+	return CPUStates{
+		User:    pct(stateUser),
+		Nice:    pct(stateNice),
+		System:  pct(stateSystem),
+		Idle:    pct(stateIdle),
+		IOWait:  pct(stateIOWait),
+		IRQ:     pct(stateIRQ),
+		SoftIRQ: pct(stateSoftIRQ),
+		Steal:   pct(stateSteal),
+	}
+}
+
+func (cs *CPUSampler) read() (cpuTimes, error) {
+	fil, err := os.Open(filepath.Join(cs.procPath, "stat"))
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer fil.Close()
+	return calculate(fil)
+}
+
+// calculate reads the tick columns of the "cpu" and "cpuN" lines of /proc/stat. Column 4 is
+// idle time. Older kernels report fewer columns, the missing states are left at zero.
+func calculate(r io.Reader) (cpuTimes, error) {
+	ret := cpuTimes{}
+	foundTotal := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		stats := utilStats{}
+		for i := 1; i < len(fields); i++ {
+			conv, err := strconv.ParseUint(fields[i], 10, 64)
 			if err != nil {
-				log.Printf("stats: encountered regex error -- %#v", err)
-				continue
+				return cpuTimes{}, err
 			}
-			coreNum, err := strconv.ParseInt(strings.ReplaceAll(string(fields[0]), "cpu", ""), 10, 64)
-			if err != nil {
-				panic(err)
+			stats.total += conv
+			if i == 4 {
+				stats.idle = conv
 			}
-
-			for i := 1; i < len(fields); i++ {
-				conv, err := strconv.ParseUint(fields[i], 10, 64)
-				if err != nil {
-					log.Printf("stats: encountered error -- %#v", err)
-					continue
-				}
-				cores[coreNum].total += conv
-				if i == 4 {
-					cores[coreNum].idle = conv
-				}
+			if i <= numStates {
+				stats.states[i-1] = conv
 			}
 		}
+
+		if fields[0] == "cpu" {
+			ret.total = stats
+			foundTotal = true
+			continue
+		}
+
+		coreNum, err := strconv.Atoi(strings.TrimPrefix(fields[0], "cpu"))
+		if err != nil || coreNum < 0 {
+			return cpuTimes{}, errors.New("status: unexpected cpu line " + fields[0])
+		}
+		for len(ret.cores) <= coreNum {
+			ret.cores = append(ret.cores, utilStats{})
+		}
+		ret.cores[coreNum] = stats
 	}
-	return
+	if err := scanner.Err(); err != nil {
+		return cpuTimes{}, err
+	}
+	if !foundTotal {
+		return cpuTimes{}, errors.New("status: no cpu line in stat")
+	}
+	return ret, nil
 }
@@ -1,8 +1,10 @@
 package status
 
 import (
+	"context"
 	"log"
 	"testing"
+	"time"
 )
 
 func TestGetUtilization(t *testing.T) {
@@ -14,7 +16,7 @@ func TestGetUtilization(t *testing.T) {
 			log.Println(i)
 		}()
 	}
-	stats, err := getUtilization()
+	stats, err := MakeCPUSampler("/proc").Sample()
 
 	if err != nil {
 		t.Log("test failed with error")
@@ -28,8 +30,81 @@ func TestGetUtilization(t *testing.T) {
 	}
 }
 
+func TestSampleWindow(t *testing.T) {
+	cs := MakeCPUSampler("testdata/cpu/first")
+	stats, err := cs.Sample()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// since boot: 300 of 1000 ticks busy, iowait counts as busy
+	if stats.Total != 30 || len(stats.Cores) != 2 {
+		t.Errorf("wanted 30%% over 2 cores, got %v", stats)
+	}
+
+	cs.procPath = "testdata/cpu/second"
+	stats, err = cs.Sample()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Total != 40 || stats.Cores[0] != 50 || stats.Cores[1] != 30 {
+		t.Errorf("wanted 40%% [50 30], got %v", stats)
+	}
+
+	expect := CPUStates{User: 20, System: 10, Idle: 60, IOWait: 10}
+	if stats.States != expect {
+		t.Errorf("wanted states %+v, got %+v", expect, stats.States)
+	}
+	expect = CPUStates{User: 20, System: 10, Idle: 70}
+	if stats.CoreStates[1] != expect {
+		t.Errorf("wanted cpu1 states %+v, got %+v", expect, stats.CoreStates[1])
+	}
+
+	// no ticks have passed, the last window is reused instead of dividing by zero
+	again, err := cs.Sample()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again.Total != 40 {
+		t.Errorf("wanted previous window on repeat sample, got %v", again)
+	}
+}
+
+func TestSampleMissingFile(t *testing.T) {
+	cs := MakeCPUSampler("testdata/does-not-exist")
+	if _, err := cs.Sample(); err == nil {
+		t.Error("wanted error for missing stat file")
+	}
+}
+
+func TestRunBackground(t *testing.T) {
+	cs := MakeCPUSampler("testdata/cpu/first")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go cs.Run(ctx, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	// concurrent readers all see the background window
+	done := make(chan CPUUtilizationStats)
+	for i := 0; i < 10; i++ {
+		go func() {
+			stats, err := cs.Utilization()
+			if err != nil {
+				t.Error(err)
+			}
+			done <- stats
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if stats := <-done; stats.Total != 30 {
+			t.Errorf("wanted 30%%, got %v", stats)
+		}
+	}
+}
+
 func BenchmarkGetUtilization(b *testing.B) {
-	// call twice to compare true difference
-	getUtilization()
-	getUtilization()
+	cs := MakeCPUSampler("/proc")
+	for i := 0; i < b.N; i++ {
+		cs.Sample()
+	}
 }
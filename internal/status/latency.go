@@ -0,0 +1,259 @@
+package status
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Histogram buckets grow by a factor of 2^(1/4) starting at 100µs, so every quantile is
+// within about 9% of the true value. Durations above the last bound land in the last bucket.
+const (
+	histogramBase    = 100 * time.Microsecond
+	histogramGrowth  = 1.189207115 // 2^(1/4)
+	histogramBuckets = 96          // up to ~16m
+)
+
+var histogramBounds = func() [histogramBuckets]time.Duration {
+	var bounds [histogramBuckets]time.Duration
+	for i := range bounds {
+		bounds[i] = time.Duration(float64(histogramBase) * math.Pow(histogramGrowth, float64(i)))
+	}
+	return bounds
+}()
+
+// Histogram counts durations into fixed exponential buckets. Because every Histogram uses
+// the same buckets, two can be merged by adding their counts, which is how windows and
+// routes are combined. The zero value is ready to use; it is not safe for concurrent use.
+type Histogram struct {
+	counts [histogramBuckets]uint64
+	count  uint64
+	errors uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// Record adds a single duration.
+func (h *Histogram) Record(d time.Duration) {
+	h.record(d, false)
+}
+
+// RecordError adds a single duration for a request that failed.
+func (h *Histogram) RecordError(d time.Duration) {
+	h.record(d, true)
+}
+
+func (h *Histogram) record(d time.Duration, failed bool) {
+	if failed {
+		h.errors++
+	}
+	idx := sort.Search(histogramBuckets, func(i int) bool { return histogramBounds[i] >= d })
+	if idx == histogramBuckets {
+		idx--
+	}
+	h.counts[idx]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds the counts of other into h.
+func (h *Histogram) Merge(other *Histogram) {
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	h.count += other.count
+	h.errors += other.errors
+	h.sum += other.sum
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count is the number of durations recorded.
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Errors is the number of durations recorded with RecordError.
+func (h *Histogram) Errors() uint64 {
+	return h.errors
+}
+
+// Max is the largest duration recorded.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean is the average duration recorded, or 0 when empty.
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Quantile estimates the duration below which q (0 to 1) of the recorded durations fall. The
+// geometric middle of the bucket holding the quantile is returned, capped at Max.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			est := histogramBounds[0]
+			if i > 0 {
+				est = time.Duration(math.Sqrt(float64(histogramBounds[i-1]) * float64(histogramBounds[i])))
+			}
+			if est > h.max {
+				return h.max
+			}
+			return est
+		}
+	}
+	return h.max
+}
+
+// LatencySummary describes the requests to a route over a window. Rate is per second and
+// ErrorRate is the fraction of requests that failed.
+type LatencySummary struct {
+	Route     string
+	Window    time.Duration
+	Count     uint64
+	Rate      float64
+	Errors    uint64
+	ErrorRate float64
+	Mean      time.Duration
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+	Max       time.Duration
+}
+
+// summarize turns a histogram covering window into a LatencySummary.
+func summarize(route string, window time.Duration, h *Histogram) LatencySummary {
+	ret := LatencySummary{
+		Route:  route,
+		Window: window,
+		Count:  h.Count(),
+		Rate:   float64(h.Count()) / window.Seconds(),
+		Errors: h.Errors(),
+		Mean:   h.Mean(),
+		P50:    h.Quantile(0.50),
+		P90:    h.Quantile(0.90),
+		P99:    h.Quantile(0.99),
+		Max:    h.Max(),
+	}
+	if ret.Count > 0 {
+		ret.ErrorRate = float64(ret.Errors) / float64(ret.Count)
+	}
+	return ret
+}
+
+// ring is a Histogram per time slot, reused once the slot falls out of the tracked span.
+type ring struct {
+	slots  []Histogram
+	epochs []int64
+}
+
+// LatencyTracker records request durations per route into a ring of time slots, so stats can
+// be read over any sliding window up to slot*slots long. It is safe for concurrent use.
+type LatencyTracker struct {
+	slot   time.Duration
+	slots  int
+	routes map[string]*ring
+	now    func() time.Time
+	mutex  sync.Mutex
+}
+
+// TotalRoute is the route name given to the summary of every route combined.
+const TotalRoute = "*"
+
+// LatencyWindows are the windows reported in the health status.
+var LatencyWindows = []time.Duration{time.Minute, 5 * time.Minute}
+
+// GlobalLatencyTracker records the time to reply on all http handlers that utilize the
+// pkg/handlers handlers.
+var GlobalLatencyTracker = MakeLatencyTracker(5*time.Second, 60)
+
+// GlobalDependencyLatency records the time taken by outgoing calls made through the
+// pkg/handlers transport, keyed by host.
+var GlobalDependencyLatency = MakeLatencyTracker(5*time.Second, 60)
+
+// MakeLatencyTracker provides a LatencyTracker that keeps slots time slots of the given
+// length.
+func MakeLatencyTracker(slot time.Duration, slots int) *LatencyTracker {
+	return &LatencyTracker{
+		slot:   slot,
+		slots:  slots,
+		routes: make(map[string]*ring),
+		now:    time.Now,
+	}
+}
+
+// Record adds the duration of a single request to route. Failed requests also count
+// towards the error rate.
+func (lt *LatencyTracker) Record(route string, d time.Duration, failed bool) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	r, ok := lt.routes[route]
+	if !ok {
+		r = &ring{
+			slots:  make([]Histogram, lt.slots),
+			epochs: make([]int64, lt.slots),
+		}
+		lt.routes[route] = r
+	}
+
+	epoch := lt.now().UnixNano() / int64(lt.slot)
+	idx := int(epoch % int64(lt.slots))
+	if r.epochs[idx] != epoch {
+		// the slot last held data from a full lap ago
+		r.slots[idx] = Histogram{}
+		r.epochs[idx] = epoch
+	}
+	r.slots[idx].record(d, failed)
+}
+
+// Snapshot summarizes each route, plus TotalRoute for all of them combined, over the most
+// recent window. The window is rounded up to whole slots and capped at the tracked span.
+// Routes are sorted by name.
+func (lt *LatencyTracker) Snapshot(window time.Duration) []LatencySummary {
+	n := int64((window + lt.slot - 1) / lt.slot)
+	if n > int64(lt.slots) {
+		n = int64(lt.slots)
+	}
+	if n < 1 {
+		n = 1
+	}
+	window = time.Duration(n) * lt.slot
+
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	curr := lt.now().UnixNano() / int64(lt.slot)
+	total := Histogram{}
+	ret := make([]LatencySummary, 0, len(lt.routes)+1)
+	for route, r := range lt.routes {
+		merged := Histogram{}
+		for i, epoch := range r.epochs {
+			if epoch > curr-n && epoch <= curr {
+				merged.Merge(&r.slots[i])
+			}
+		}
+		total.Merge(&merged)
+		ret = append(ret, summarize(route, window, &merged))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Route < ret[j].Route })
+	return append(ret, summarize(TotalRoute, window, &total))
+}
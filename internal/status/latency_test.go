@@ -0,0 +1,94 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+// within checks the estimate is inside the ~9% bucket error of the true value.
+func within(actual, expect time.Duration) bool {
+	diff := float64(actual-expect) / float64(expect)
+	return diff > -0.1 && diff < 0.1
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	h := Histogram{}
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	var testCases = []struct {
+		name   string
+		actual time.Duration
+		expect time.Duration
+	}{
+		{"p50", h.Quantile(0.5), 500 * time.Millisecond},
+		{"p90", h.Quantile(0.9), 900 * time.Millisecond},
+		{"p99", h.Quantile(0.99), 990 * time.Millisecond},
+		{"mean", h.Mean(), 500500 * time.Microsecond},
+	}
+	for _, test := range testCases {
+		if !within(test.actual, test.expect) {
+			t.Errorf("%s: wanted ~%v, got %v", test.name, test.expect, test.actual)
+		}
+	}
+	if h.Max() != time.Second || h.Quantile(1) != time.Second {
+		t.Errorf("wanted max 1s, got %v and %v", h.Max(), h.Quantile(1))
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	fast, slow := Histogram{}, Histogram{}
+	for i := 0; i < 90; i++ {
+		fast.Record(10 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		slow.Record(time.Second)
+	}
+
+	fast.Merge(&slow)
+	if fast.Count() != 100 {
+		t.Errorf("wanted 100, got %d", fast.Count())
+	}
+	if p50 := fast.Quantile(0.5); !within(p50, 10*time.Millisecond) {
+		t.Errorf("wanted p50 ~10ms, got %v", p50)
+	}
+	if p99 := fast.Quantile(0.99); p99 != time.Second {
+		t.Errorf("wanted p99 1s, got %v", p99)
+	}
+}
+
+func TestLatencyTrackerWindows(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	lt := MakeLatencyTracker(time.Second, 10)
+	lt.now = func() time.Time { return clock }
+
+	// an old slow request, then recent fast ones on two routes
+	lt.Record("/slow", 2*time.Second, true)
+	clock = clock.Add(5 * time.Second)
+	for i := 0; i < 4; i++ {
+		lt.Record("/fast", 10*time.Millisecond, false)
+	}
+
+	recent := lt.Snapshot(2 * time.Second)
+	if len(recent) != 3 || recent[0].Route != "/fast" || recent[1].Route != "/slow" || recent[2].Route != TotalRoute {
+		t.Fatalf("unexpected routes %v", recent)
+	}
+	if recent[0].Count != 4 || recent[0].Rate != 2 || recent[1].Count != 0 {
+		t.Errorf("wanted only fast requests in window, got %+v", recent)
+	}
+
+	all := lt.Snapshot(10 * time.Second)
+	total := all[len(all)-1]
+	if total.Count != 5 || total.Max != 2*time.Second || total.Errors != 1 || total.ErrorRate != 0.2 {
+		t.Errorf("wanted both routes in total, got %+v", total)
+	}
+
+	// a full lap later the ring slots are reused
+	clock = clock.Add(20 * time.Second)
+	lt.Record("/fast", time.Millisecond, false)
+	all = lt.Snapshot(10 * time.Second)
+	if total := all[len(all)-1]; total.Count != 1 {
+		t.Errorf("wanted old slots dropped, got %+v", total)
+	}
+}
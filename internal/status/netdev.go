@@ -0,0 +1,203 @@
+package status
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InterfaceStats holds the traffic seen on a single network interface between the last two
+// samples. Every value is a per second rate.
+type InterfaceStats struct {
+	Name      string
+	RxBytes   float64
+	RxPackets float64
+	RxErrors  float64
+	RxDrops   float64
+	TxBytes   float64
+	TxPackets float64
+	TxErrors  float64
+	TxDrops   float64
+}
+
+type ifaceCounters struct {
+	rxBytes, rxPackets, rxErrors, rxDrops uint64
+	txBytes, txPackets, txErrors, txDrops uint64
+}
+
+// NetworkSampler reads the interface counters in /proc/net/dev and the socket tables in
+// /proc/net/tcp{,6}. Interface counters are turned into rates using the previous sample,
+// so the first call will report zero for every interface.
+type NetworkSampler struct {
+	procPath string
+	prev     map[string]ifaceCounters
+	prevTime time.Time
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+// GlobalNetworkSampler is the sampler used when building the health status of this process.
+var GlobalNetworkSampler = MakeNetworkSampler("/proc")
+
+// MakeNetworkSampler provides a NetworkSampler that reads from the proc filesystem mounted
+// at procPath.
+func MakeNetworkSampler(procPath string) *NetworkSampler {
+	return &NetworkSampler{
+		procPath: procPath,
+		prev:     make(map[string]ifaceCounters),
+		now:      time.Now,
+	}
+}
+
+// Interfaces reads /proc/net/dev and returns the rates for every interface since the last
+// call, sorted by interface name.
+func (ns *NetworkSampler) Interfaces() ([]InterfaceStats, error) {
+	fil, err := os.Open(filepath.Join(ns.procPath, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer fil.Close()
+
+	counters, err := parseNetDev(fil)
+	if err != nil {
+		return nil, err
+	}
+
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	now := ns.now()
+	elapsed := now.Sub(ns.prevTime).Seconds()
+	first := ns.prevTime.IsZero()
+
+	ret := make([]InterfaceStats, 0, len(counters))
+	for name, curr := range counters {
+		stats := InterfaceStats{Name: name}
+		if prev, ok := ns.prev[name]; ok && !first && elapsed > 0 {
+			stats.RxBytes = rate(prev.rxBytes, curr.rxBytes, elapsed)
+			stats.RxPackets = rate(prev.rxPackets, curr.rxPackets, elapsed)
+			stats.RxErrors = rate(prev.rxErrors, curr.rxErrors, elapsed)
+			stats.RxDrops = rate(prev.rxDrops, curr.rxDrops, elapsed)
+			stats.TxBytes = rate(prev.txBytes, curr.txBytes, elapsed)
+			stats.TxPackets = rate(prev.txPackets, curr.txPackets, elapsed)
+			stats.TxErrors = rate(prev.txErrors, curr.txErrors, elapsed)
+			stats.TxDrops = rate(prev.txDrops, curr.txDrops, elapsed)
+		}
+		ret = append(ret, stats)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	ns.prev = counters
+	ns.prevTime = now
+
+	return ret, nil
+}
+
+// rate gives the per second change between two counter readings. A counter that went
+// backwards was reset (interface restarted or wrapped), so the current value is used.
+func rate(prev, curr uint64, elapsed float64) float64 {
+	if curr < prev {
+		return float64(curr) / elapsed
+	}
+	return float64(curr-prev) / elapsed
+}
+
+// parseNetDev reads the /proc/net/dev format. The first two lines are headers, every other
+// line is "iface: <8 receive fields> <8 transmit fields>".
+func parseNetDev(r io.Reader) (map[string]ifaceCounters, error) {
+	ret := make(map[string]ifaceCounters)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		sep := strings.Index(line, ":")
+		if sep < 0 {
+			continue // header
+		}
+		name := strings.TrimSpace(line[:sep])
+		fields := strings.Fields(line[sep+1:])
+		if len(fields) < 16 {
+			continue
+		}
+
+		vals := make([]uint64, 16)
+		for i := range vals {
+			conv, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = conv
+		}
+
+		ret[name] = ifaceCounters{
+			rxBytes:   vals[0],
+			rxPackets: vals[1],
+			rxErrors:  vals[2],
+			rxDrops:   vals[3],
+			txBytes:   vals[8],
+			txPackets: vals[9],
+			txErrors:  vals[10],
+			txDrops:   vals[11],
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// tcpStates maps the hex state column of /proc/net/tcp to the names used by netstat.
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+	"0C": "NEW_SYN_RECV",
+}
+
+// TCPStates counts the sockets in /proc/net/tcp and /proc/net/tcp6 by connection state. A
+// missing tcp6 table (IPv6 disabled) is not treated as an error.
+func (ns *NetworkSampler) TCPStates() (map[string]int, error) {
+	ret := make(map[string]int)
+	for _, table := range []string{"tcp", "tcp6"} {
+		fil, err := os.Open(filepath.Join(ns.procPath, "net", table))
+		if os.IsNotExist(err) && table == "tcp6" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = countTCPStates(fil, ret)
+		fil.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func countTCPStates(r io.Reader, counts map[string]int) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		state, ok := tcpStates[strings.ToUpper(fields[3])]
+		if !ok {
+			state = "UNKNOWN"
+		}
+		counts[state]++
+	}
+	return scanner.Err()
+}
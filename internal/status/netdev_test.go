@@ -0,0 +1,76 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInterfaceRates(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	ns := MakeNetworkSampler("testdata/netdev/first")
+	ns.now = func() time.Time { return clock }
+
+	// first sample has nothing to compare against
+	stats, err := ns.Interfaces()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Name != "eth0" || stats[1].Name != "lo" {
+		t.Fatalf("expected sorted [eth0 lo], got %v", stats)
+	}
+	if stats[0].RxBytes != 0 {
+		t.Errorf("expected zero rate on first sample, got %v", stats[0].RxBytes)
+	}
+
+	clock = clock.Add(2 * time.Second)
+	ns.procPath = "testdata/netdev/second"
+	stats, err = ns.Interfaces()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	eth0 := stats[0]
+	var testCases = []struct {
+		name   string
+		actual float64
+		expect float64
+	}{
+		{"rx_bytes", eth0.RxBytes, 10000},
+		{"rx_packets", eth0.RxPackets, 20},
+		{"rx_errors", eth0.RxErrors, 1},
+		{"rx_drops", eth0.RxDrops, 0},
+		{"tx_bytes", eth0.TxBytes, 5000},
+		{"tx_packets", eth0.TxPackets, 10},
+		{"tx_drops", eth0.TxDrops, 2},
+		{"lo_rx_bytes", stats[1].RxBytes, 1000},
+	}
+	for _, test := range testCases {
+		if test.actual != test.expect {
+			t.Errorf("%s: wanted %v, got %v", test.name, test.expect, test.actual)
+		}
+	}
+}
+
+func TestTCPStates(t *testing.T) {
+	ns := MakeNetworkSampler("testdata/netdev/first")
+	states, err := ns.TCPStates()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expect := map[string]int{"LISTEN": 2, "ESTABLISHED": 1, "TIME_WAIT": 1}
+	if len(states) != len(expect) {
+		t.Errorf("wanted %v, got %v", expect, states)
+	}
+	for state, count := range expect {
+		if states[state] != count {
+			t.Errorf("%s: wanted %d, got %d", state, count, states[state])
+		}
+	}
+
+	// tcp6 is optional
+	ns.procPath = "testdata/netdev/second"
+	if _, err := ns.TCPStates(); err != nil {
+		t.Errorf("missing tcp6 should not error: %v", err)
+	}
+}
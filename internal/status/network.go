@@ -2,15 +2,17 @@ package status
 
 import (
 	"math"
+	"sync"
 )
 
 // ResponseAverager contains a list of vals that is limited by maxN. When a value is added
 // passed the maxN value, the oldest entry is removed and the new one added. Ie, vals.Len()
 // will never be over maxN.
 type ResponseAverager struct {
-	vals []int
-	curr int
-	div  int
+	vals  []int
+	curr  int
+	div   int
+	mutex sync.Mutex
 }
 
 // GlobalNetworkInformation contains the Averager for the time to reply on all http
@@ -27,23 +29,42 @@ func init() {
 // AddVal adds a new value, ensuring that the length of the list is not over maxN.
 // If it is, it will remove the oldest entry, if not it will add like a normal list.
 func (avger *ResponseAverager) AddVal(val int) {
+	avger.mutex.Lock()
+	defer avger.mutex.Unlock()
 	ind := avger.div % 50         // max supported is 50
 	avger.vals[ind] = val         // store the previous value in the next free index
 	avger.curr = avger.curr + val // get our running total
 	avger.div++                   // increment our division counter
 }
 
-// Average gets the mean value of all items in the list.
+// Average gets the mean value of all items in the list, or 0 before any are added.
 func (avger *ResponseAverager) Average() float64 {
+	avger.mutex.Lock()
+	defer avger.mutex.Unlock()
+	if avger.div == 0 {
+		return 0 // NaN can not be encoded to json
+	}
 	avg := float64(avger.curr) / float64(avger.div)
 	return math.Round(avg*100) / 100
 }
 
-// AverageLastN returns the average of the last N values seen
+// AverageLastN returns the average of the last N values seen. N is capped at the number of
+// values seen and the 50 kept.
 func (avger *ResponseAverager) AverageLastN(n int) float64 {
+	avger.mutex.Lock()
+	defer avger.mutex.Unlock()
+	if n > avger.div {
+		n = avger.div
+	}
+	if n > len(avger.vals) {
+		n = len(avger.vals)
+	}
+	if n <= 0 {
+		return 0
+	}
 	var total int
-	for i := 0; i < n; i++ {
-		total += avger.vals[i]
+	for i := 1; i <= n; i++ {
+		total += avger.vals[(avger.div-i)%len(avger.vals)]
 	}
 	avg := float64(total) / float64(n)
 	return math.Round(avg*100) / 100
@@ -14,12 +14,12 @@ func TestOngoingAverages(t *testing.T) {
 
 	for _, test := range testCases {
 		t.Run("Base", func(t *testing.T) {
-			valchan := make(chan int, len(test.values))
+			avger := &ResponseAverager{vals: make([]int, 50)}
 			for _, val := range test.values {
-				valchan <- val
+				avger.AddVal(val)
 			}
 
-			avg := GlobalNetworkInformation.Average()
+			avg := avger.Average()
 
 			if avg < (test.average-0.01) || avg > (test.average+0.01) {
 				t.Logf("averages did not match. wanted: %v, got: %v", test.average, avg)
@@ -28,3 +28,26 @@ func TestOngoingAverages(t *testing.T) {
 		})
 	}
 }
+
+func TestAverageLastN(t *testing.T) {
+	avger := &ResponseAverager{vals: make([]int, 50)}
+	for i := 1; i <= 60; i++ {
+		avger.AddVal(i)
+	}
+
+	// most recent values, not the first slots of the ring
+	if avg := avger.AverageLastN(3); avg != 59 {
+		t.Errorf("wanted average of 58..60, got %v", avg)
+	}
+	// capped at the 50 kept
+	if avg := avger.AverageLastN(100); avg != 35.5 {
+		t.Errorf("wanted average of 11..60, got %v", avg)
+	}
+	empty := &ResponseAverager{vals: make([]int, 50)}
+	if avg := empty.AverageLastN(5); avg != 0 {
+		t.Errorf("wanted 0 with no values, got %v", avg)
+	}
+	if avg := empty.Average(); avg != 0 {
+		t.Errorf("wanted 0 average with no values, got %v", avg)
+	}
+}
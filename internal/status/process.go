@@ -0,0 +1,176 @@
+package status
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockTicks is the USER_HZ the kernel uses for the time fields in /proc/<pid>/stat. It is
+// 100 on every architecture Go supports on linux.
+const clockTicks = 100
+
+// LoadStats holds the contents of /proc/loadavg.
+type LoadStats struct {
+	Load1   float64
+	Load5   float64
+	Load15  float64
+	Running int
+	Total   int
+}
+
+// ProcessStats holds resource usage for a single process. CPU is a percentage of one core,
+// so a busy multithreaded process can report over 100.
+type ProcessStats struct {
+	CPU     float64
+	Threads int
+	FDs     int
+}
+
+// ReadLoadAverage reads the 1, 5 and 15 minute load averages along with the running and
+// total scheduling entity counts from the proc filesystem mounted at procPath.
+func ReadLoadAverage(procPath string) (LoadStats, error) {
+	fil, err := ioutil.ReadFile(filepath.Join(procPath, "loadavg"))
+	if err != nil {
+		return LoadStats{}, err
+	}
+	fields := strings.Fields(string(fil))
+	if len(fields) < 4 {
+		return LoadStats{}, errors.New("status: unexpected loadavg format")
+	}
+
+	ret := LoadStats{}
+	for i, dst := range []*float64{&ret.Load1, &ret.Load5, &ret.Load15} {
+		if *dst, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return LoadStats{}, err
+		}
+	}
+
+	entities := strings.Split(fields[3], "/")
+	if len(entities) != 2 {
+		return LoadStats{}, errors.New("status: unexpected loadavg format")
+	}
+	if ret.Running, err = strconv.Atoi(entities[0]); err != nil {
+		return LoadStats{}, err
+	}
+	if ret.Total, err = strconv.Atoi(entities[1]); err != nil {
+		return LoadStats{}, err
+	}
+	return ret, nil
+}
+
+// ReadUptime reads how long the system has been running from /proc/uptime.
+func ReadUptime(procPath string) (time.Duration, error) {
+	fil, err := ioutil.ReadFile(filepath.Join(procPath, "uptime"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(fil))
+	if len(fields) < 1 {
+		return 0, errors.New("status: unexpected uptime format")
+	}
+	secs, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// ProcessSampler reports the resource usage of a process from /proc/<pid>. CPU usage is
+// measured between calls; the first call reports the average over the life of the process.
+type ProcessSampler struct {
+	procPath string
+	pid      string
+	prevCPU  uint64
+	prevTime time.Duration // system uptime at the previous sample
+	mutex    sync.Mutex
+}
+
+// GlobalProcessSampler is the sampler for the process this package is running in.
+var GlobalProcessSampler = MakeProcessSampler("/proc", "self")
+
+// MakeProcessSampler provides a ProcessSampler for pid ("self" for the current process)
+// reading from the proc filesystem mounted at procPath.
+func MakeProcessSampler(procPath, pid string) *ProcessSampler {
+	return &ProcessSampler{
+		procPath: procPath,
+		pid:      pid,
+	}
+}
+
+// Sample reads the current CPU usage, thread count and open file descriptor count.
+func (ps *ProcessSampler) Sample() (ProcessStats, error) {
+	procDir := filepath.Join(ps.procPath, ps.pid)
+	fil, err := ioutil.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return ProcessStats{}, err
+	}
+	cpuTicks, startTicks, threads, err := parseProcStat(string(fil))
+	if err != nil {
+		return ProcessStats{}, err
+	}
+	uptime, err := ReadUptime(ps.procPath)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+	fds, err := ioutil.ReadDir(filepath.Join(procDir, "fd"))
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	prevCPU, prevTime := ps.prevCPU, ps.prevTime
+	if prevTime == 0 {
+		prevTime = time.Duration(startTicks) * time.Second / clockTicks
+	}
+	ps.prevCPU, ps.prevTime = cpuTicks, uptime
+
+	ret := ProcessStats{
+		Threads: threads,
+		FDs:     len(fds),
+	}
+	if wall := (uptime - prevTime).Seconds(); wall > 0 && cpuTicks >= prevCPU {
+		used := float64(cpuTicks-prevCPU) / clockTicks
+		ret.CPU = used / wall * 100
+	}
+	return ret, nil
+}
+
+// parseProcStat pulls utime+stime, starttime and num_threads out of /proc/<pid>/stat. The
+// command name is wrapped in parentheses and may contain spaces, so fields are counted from
+// the last closing parenthesis.
+func parseProcStat(stat string) (cpuTicks, startTicks uint64, threads int, err error) {
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return 0, 0, 0, errors.New("status: unexpected stat format")
+	}
+	// fields[0] is the state, field 3 in proc(5)
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return 0, 0, 0, errors.New("status: unexpected stat format")
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	threads, err = strconv.Atoi(fields[17])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	startTicks, err = strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return utime + stime, startTicks, threads, nil
+}
@@ -0,0 +1,60 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadLoadAverage(t *testing.T) {
+	load, err := ReadLoadAverage("testdata/process")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := LoadStats{0.62, 0.32, 0.13, 2, 72}
+	if load != expect {
+		t.Errorf("wanted %v, got %v", expect, load)
+	}
+}
+
+func TestReadUptime(t *testing.T) {
+	uptime, err := ReadUptime("testdata/process")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uptime != 200*time.Second {
+		t.Errorf("wanted 200s, got %v", uptime)
+	}
+}
+
+func TestProcessSample(t *testing.T) {
+	ps := MakeProcessSampler("testdata/process", "42")
+	stats, err := ps.Sample()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 20s of cpu over the 100s since the process started
+	expect := ProcessStats{CPU: 20, Threads: 7, FDs: 3}
+	if stats != expect {
+		t.Errorf("wanted %v, got %v", expect, stats)
+	}
+
+	// no time has passed, so there is no window to measure
+	stats, err = ps.Sample()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.CPU != 0 {
+		t.Errorf("wanted 0 cpu for empty window, got %v", stats.CPU)
+	}
+}
+
+func TestProcessSampleSelf(t *testing.T) {
+	stats, err := GlobalProcessSampler.Sample()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Threads < 1 || stats.FDs < 1 {
+		t.Errorf("expected at least one thread and fd, got %v", stats)
+	}
+}
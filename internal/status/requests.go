@@ -0,0 +1,111 @@
+package status
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// RequestCount is the running total of requests with the same method, route and status
+// class ("2xx", "5xx", ... or a gRPC code). Bytes is the total size of the response bodies
+// written.
+type RequestCount struct {
+	Method string
+	Route  string
+	Class  string
+	Count  uint64
+	Bytes  uint64
+}
+
+type requestKey struct {
+	method string
+	route  string
+	class  string
+}
+
+// RequestTracker counts requests by method, route and status class and keeps a gauge of the
+// requests currently being served. It is safe for concurrent use.
+type RequestTracker struct {
+	inFlight int64
+	counts   map[requestKey]*RequestCount
+	mutex    sync.Mutex
+}
+
+// GlobalRequestTracker counts the requests to all http handlers that utilize the
+// pkg/handlers handlers.
+var GlobalRequestTracker = MakeRequestTracker()
+
+// GlobalDependencyRequests counts the outgoing calls made through the pkg/handlers
+// transport, keyed by host.
+var GlobalDependencyRequests = MakeRequestTracker()
+
+// MakeRequestTracker provides an empty RequestTracker.
+func MakeRequestTracker() *RequestTracker {
+	return &RequestTracker{
+		counts: make(map[requestKey]*RequestCount),
+	}
+}
+
+// StatusClass groups a status code into its class, ie 404 is "4xx".
+func StatusClass(code int) string {
+	if code < 100 || code > 999 {
+		return "unknown"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// Begin marks a request as in flight. Every Begin must be followed by a Done.
+func (rt *RequestTracker) Begin() {
+	atomic.AddInt64(&rt.inFlight, 1)
+}
+
+// Done records a finished http request and removes it from the in flight gauge.
+func (rt *RequestTracker) Done(method, route string, code int, size int64) {
+	rt.DoneClass(method, route, StatusClass(code), size)
+}
+
+// DoneClass is Done for transports whose status does not fit an http class, such as gRPC
+// codes.
+func (rt *RequestTracker) DoneClass(method, route, class string, size int64) {
+	atomic.AddInt64(&rt.inFlight, -1)
+
+	key := requestKey{method, route, class}
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	count, ok := rt.counts[key]
+	if !ok {
+		count = &RequestCount{Method: key.method, Route: key.route, Class: key.class}
+		rt.counts[key] = count
+	}
+	count.Count++
+	if size > 0 {
+		count.Bytes += uint64(size)
+	}
+}
+
+// InFlight is the number of requests currently being served.
+func (rt *RequestTracker) InFlight() int64 {
+	return atomic.LoadInt64(&rt.inFlight)
+}
+
+// Counts returns a copy of every count, sorted by route, method then class.
+func (rt *RequestTracker) Counts() []RequestCount {
+	rt.mutex.Lock()
+	ret := make([]RequestCount, 0, len(rt.counts))
+	for _, count := range rt.counts {
+		ret = append(ret, *count)
+	}
+	rt.mutex.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Route != ret[j].Route {
+			return ret[i].Route < ret[j].Route
+		}
+		if ret[i].Method != ret[j].Method {
+			return ret[i].Method < ret[j].Method
+		}
+		return ret[i].Class < ret[j].Class
+	})
+	return ret
+}
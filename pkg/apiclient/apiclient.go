@@ -0,0 +1,196 @@
+// Package apiclient queries an aidi server through its /aidi/v1 api, for tools that read
+// what the server knows rather than being monitored by it; those use pkg/client.
+//
+// It covers listing and fetching clients and statuses and subscribing to status changes.
+// Fetching status history and listing alerts are not supported, as the server does not
+// keep history or raise alerts yet; they will be added here once the api serves them.
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// Defaults used when the matching Config field is zero.
+const (
+	DefaultTimeout    = 30 * time.Second
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Config describes how to call the server. AuthHeader, when set, is sent as the
+// Authorization header. Timeout bounds each call, including the wait of a long poll.
+// Subscribe retries failed polls with exponential backoff between MinBackoff and
+// MaxBackoff.
+type Config struct {
+	AuthHeader string
+	HTTPClient *http.Client
+	Timeout    time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Client calls the /aidi/v1 api of one aidi server.
+type Client struct {
+	base   string
+	config Config
+}
+
+// MakeClient provides a Client for the server at baseURL, ie "http://aidi:9900".
+func MakeClient(baseURL string, config Config) *Client {
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	return &Client{
+		base:   strings.TrimSuffix(baseURL, "/"),
+		config: config,
+	}
+}
+
+// ListOptions narrows and orders a list. Selector is a label selector as read by
+// models.ParseSelector, Sort a field to sort by, prefixed by "-" for descending order, and
+// Limit and Offset pick the page; the server's defaults apply to the zero values.
+type ListOptions struct {
+	Selector string
+	Sort     string
+	Limit    int
+	Offset   int
+}
+
+func (opts ListOptions) query() url.Values {
+	query := url.Values{}
+	if opts.Selector != "" {
+		query.Set("selector", opts.Selector)
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	return query
+}
+
+// Error is a call the server answered with a failure. API holds the error body of the
+// versioned api, or just a code derived from StatusCode when the server sent none.
+type Error struct {
+	StatusCode int
+	API        models.APIError
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("aidi server responded with status %d -- %v", err.StatusCode, err.API)
+}
+
+// IsNotFound reports whether err is the server saying the requested item does not exist.
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Clients lists a page of the registered and discovered clients.
+func (c *Client) Clients(ctx context.Context, opts ListOptions) (models.ClientList, error) {
+	list := models.ClientList{}
+	err := c.get(ctx, "/aidi/v1/clients", opts.query(), &list)
+	return list, err
+}
+
+// ClientInfo gets a client by its ID, see models.ClientInfo.ID.
+func (c *Client) ClientInfo(ctx context.Context, id string) (models.ClientInfo, error) {
+	info := models.ClientInfo{}
+	err := c.get(ctx, "/aidi/v1/clients/"+url.PathEscape(id), nil, &info)
+	return info, err
+}
+
+// Statuses lists a page of the latest status of every client.
+func (c *Client) Statuses(ctx context.Context, opts ListOptions) (models.StatusList, error) {
+	list := models.StatusList{}
+	err := c.get(ctx, "/aidi/v1/statuses", opts.query(), &list)
+	return list, err
+}
+
+// Status gets the latest status of a client by its ID.
+func (c *Client) Status(ctx context.Context, id string) (models.ClientStatus, error) {
+	status := models.ClientStatus{}
+	err := c.get(ctx, "/aidi/v1/statuses/"+url.PathEscape(id), nil, &status)
+	return status, err
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, body interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.config.AuthHeader != "" {
+		req.Header.Set("Authorization", c.config.AuthHeader)
+	}
+
+	resp, err := c.config.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
+		return fmt.Errorf("could not decode response from %s -- %v", path, err)
+	}
+	return nil
+}
+
+// responseError reads the error body of a failed call, falling back to the status alone for
+// servers, or proxies in front of them, that do not send one.
+func responseError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	errResp := models.ErrorResponse{}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := json.Unmarshal(data, &errResp); err == nil && errResp.Error.Code != "" {
+		apiErr.API = errResp.Error
+		return apiErr
+	}
+
+	apiErr.API.Message = strings.TrimSpace(string(data))
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		apiErr.API.Code = models.ErrCodeBadRequest
+	case http.StatusNotFound:
+		apiErr.API.Code = models.ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		apiErr.API.Code = models.ErrCodeMethodNotAllowed
+	default:
+		apiErr.API.Code = models.ErrCodeInternal
+	}
+	return apiErr
+}
@@ -0,0 +1,169 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// fakeServer answers like the /aidi/v1 api of an aidi server. Long polls wait for a round
+// to be sent on rounds; failing makes the status list answer with a 503 that many times.
+type fakeServer struct {
+	auth    atomic.Value
+	query   atomic.Value
+	rounds  chan int
+	failing int32
+}
+
+func (fs *fakeServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/aidi/v1/clients", func(w http.ResponseWriter, r *http.Request) {
+		fs.auth.Store(r.Header.Get("Authorization"))
+		fs.query.Store(r.URL.Query())
+		json.NewEncoder(w).Encode(models.ClientList{
+			Items: []models.ClientInfo{{CName: "orders", Instance: "orders-1"}},
+			Page:  models.Page{Total: 3, Offset: 1, Limit: 1},
+		})
+	})
+	mux.HandleFunc("/aidi/v1/clients/", func(w http.ResponseWriter, r *http.Request) {
+		if id := strings.TrimPrefix(r.URL.Path, "/aidi/v1/clients/"); id != "orders@[fd00::1]:8080" {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(models.ClientInfo{CName: "orders", Instance: "[fd00::1]:8080"})
+	})
+	mux.HandleFunc("/aidi/v1/statuses/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.ErrorResponse{Error: models.APIError{Code: models.ErrCodeNotFound, Message: "could not find status"}})
+	})
+	mux.HandleFunc("/aidi/v1/statuses", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("selector") == "=bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{Error: models.APIError{Code: models.ErrCodeBadRequest, Fields: []models.FieldError{{Field: "selector"}}}})
+			return
+		}
+		if atomic.AddInt32(&fs.failing, -1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		round := 0
+		if r.URL.Query().Get("poll") == "true" {
+			select {
+			case round = <-fs.rounds:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(models.StatusList{
+			Items: []models.ClientStatus{{ID: "orders", Name: "orders", Updated: int64(round)}},
+			Page:  models.Page{Total: 1, Limit: 100},
+		})
+	})
+	return mux
+}
+
+func TestLists(t *testing.T) {
+	fs := &fakeServer{}
+	srv := httptest.NewServer(fs.handler())
+	defer srv.Close()
+	cli := MakeClient(srv.URL+"/", Config{AuthHeader: "Bearer token"})
+
+	clients, err := cli.Clients(context.Background(), ListOptions{Selector: "env=prod", Sort: "-name", Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(clients.Items) != 1 || clients.Items[0].ID() != "orders@orders-1" || clients.Page.Total != 3 {
+		t.Errorf("wanted a page of orders, got %+v", clients)
+	}
+	query := fs.query.Load().(url.Values)
+	if query.Get("selector") != "env=prod" || query.Get("sort") != "-name" || query.Get("limit") != "1" || query.Get("offset") != "1" {
+		t.Errorf("wanted options sent as query, got %v", query)
+	}
+	if auth := fs.auth.Load().(string); auth != "Bearer token" {
+		t.Errorf("wanted auth header sent, got %q", auth)
+	}
+
+	info, err := cli.ClientInfo(context.Background(), "orders@[fd00::1]:8080")
+	if err != nil || info.Instance != "[fd00::1]:8080" {
+		t.Errorf("wanted client by id, got %+v %v", info, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer((&fakeServer{}).handler())
+	defer srv.Close()
+	cli := MakeClient(srv.URL, Config{})
+
+	_, err := cli.Status(context.Background(), "orders")
+	if !IsNotFound(err) || err.(*Error).API.Message != "could not find status" {
+		t.Errorf("wanted not found from the error body, got %v", err)
+	}
+
+	// a proxy answering in plain text still gives an Error
+	_, err = cli.ClientInfo(context.Background(), "payments")
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusBadGateway || apiErr.API.Code != models.ErrCodeInternal || apiErr.API.Message != "Bad Gateway" {
+		t.Errorf("wanted error from a plain text body, got %#v", err)
+	}
+
+	_, err = cli.Statuses(context.Background(), ListOptions{Selector: "=bad"})
+	if apiErr, ok := err.(*Error); !ok || len(apiErr.API.Fields) != 1 {
+		t.Errorf("wanted field errors, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cli.Clients(ctx, ListOptions{}); err == nil {
+		t.Error("wanted error for a cancelled context")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	fs := &fakeServer{rounds: make(chan int), failing: 1}
+	srv := httptest.NewServer(fs.handler())
+	defer srv.Close()
+	cli := MakeClient(srv.URL, Config{MinBackoff: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := cli.Subscribe(ctx, ListOptions{})
+
+	// the failure is delivered, then the current statuses once it recovers
+	if update := <-updates; update.Err == nil {
+		t.Errorf("wanted the failed call delivered, got %+v", update)
+	}
+	if update := <-updates; update.Err != nil || update.Statuses.Items[0].Updated != 0 {
+		t.Errorf("wanted current statuses, got %+v", update)
+	}
+	for round := 1; round <= 2; round++ {
+		fs.rounds <- round
+		if update := <-updates; update.Err != nil || update.Statuses.Items[0].Updated != int64(round) {
+			t.Errorf("wanted round %d, got %+v", round, update)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-updates:
+		if ok {
+			t.Error("wanted no more updates once cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Error("wanted updates closed once cancelled")
+	}
+
+	// an invalid subscription is not retried
+	updates = cli.Subscribe(context.Background(), ListOptions{Selector: "=bad"})
+	if update := <-updates; update.Err == nil {
+		t.Errorf("wanted the rejection delivered, got %+v", update)
+	}
+	if _, ok := <-updates; ok {
+		t.Error("wanted updates closed after a rejection")
+	}
+}
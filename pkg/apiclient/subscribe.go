@@ -0,0 +1,63 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// Update is one delivery of Subscribe: the statuses after a round of polling, or the error
+// of a call that failed.
+type Update struct {
+	Statuses models.StatusList
+	Err      error
+}
+
+// Subscribe sends the statuses matching opts right away and again after every round of
+// polling on the server, until ctx ends and the channel is closed. Failed calls are sent as
+// an Update and retried with backoff, starting over with the current statuses, except for
+// requests the server rejects as invalid, which close the channel.
+func (c *Client) Subscribe(ctx context.Context, opts ListOptions) <-chan Update {
+	updates := make(chan Update)
+	go func() {
+		defer close(updates)
+		backoff := c.config.MinBackoff
+		current := false
+		for {
+			query := opts.query()
+			if current {
+				query.Set("poll", "true")
+			}
+			list := models.StatusList{}
+			err := c.get(ctx, "/aidi/v1/statuses", query, &list)
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case updates <- Update{Statuses: list, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+
+			if err == nil {
+				current, backoff = true, c.config.MinBackoff
+				continue
+			}
+			if apiErr, ok := err.(*Error); ok && apiErr.StatusCode == http.StatusBadRequest {
+				return
+			}
+			current = false
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			if backoff *= 2; backoff > c.config.MaxBackoff {
+				backoff = c.config.MaxBackoff
+			}
+		}
+	}()
+	return updates
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// DefaultCheckTimeout is used for checks registered without a timeout.
+const DefaultCheckTimeout = 5 * time.Second
+
+// ErrDuplicateCheck is returned when a check is registered under a name that is already in
+// use.
+var ErrDuplicateCheck = errors.New("client: check already registered")
+
+// ErrCheckTimeout is reported when a check does not return before its timeout.
+var ErrCheckTimeout = errors.New("check timed out")
+
+// CheckFunc reports whether a dependency of the application is healthy. It should return
+// promptly once ctx is done.
+type CheckFunc func(ctx context.Context) error
+
+// CheckOptions controls how a check is run. A failing Critical check marks the whole client
+// down, other failures only degrade it. When CacheFor is set the last result is reused until
+// it is older than CacheFor, which keeps expensive checks off the scrape path; a run cut
+// short because the caller gave up is not cached. Liveness
+// checks are also run by the /livez probe; leave it off for checks of external dependencies
+// so an outage elsewhere does not get the process restarted.
+type CheckOptions struct {
+	Timeout  time.Duration
+	Critical bool
+	CacheFor time.Duration
+	Liveness bool
+}
+
+type check struct {
+	name  string
+	fn    CheckFunc
+	opts  CheckOptions
+	last  models.CheckResult
+	ran   time.Time
+	mutex sync.Mutex
+}
+
+// Checker holds the named health checks of an application.
+type Checker struct {
+	checks map[string]*check
+	mutex  sync.RWMutex
+}
+
+// DefaultChecker is the checker used by clients made with MakeClient.
+var DefaultChecker = MakeChecker()
+
+// MakeChecker provides an empty Checker.
+func MakeChecker() *Checker {
+	return &Checker{
+		checks: make(map[string]*check),
+	}
+}
+
+// Register adds a check under name. ErrDuplicateCheck is returned if the name is taken.
+func (ch *Checker) Register(name string, fn CheckFunc, opts CheckOptions) error {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultCheckTimeout
+	}
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	if _, ok := ch.checks[name]; ok {
+		return ErrDuplicateCheck
+	}
+	ch.checks[name] = &check{name: name, fn: fn, opts: opts}
+	return nil
+}
+
+// Unregister removes the check under name, if there is one.
+func (ch *Checker) Unregister(name string) {
+	ch.mutex.Lock()
+	delete(ch.checks, name)
+	ch.mutex.Unlock()
+}
+
+// Run runs every check concurrently, reusing cached results where allowed, and returns the
+// results sorted by name.
+func (ch *Checker) Run(ctx context.Context) []models.CheckResult {
+	return ch.run(ctx, func(CheckOptions) bool { return true })
+}
+
+// RunLiveness runs only the checks registered with Liveness set.
+func (ch *Checker) RunLiveness(ctx context.Context) []models.CheckResult {
+	return ch.run(ctx, func(opts CheckOptions) bool { return opts.Liveness })
+}
+
+func (ch *Checker) run(ctx context.Context, include func(CheckOptions) bool) []models.CheckResult {
+	ch.mutex.RLock()
+	checks := make([]*check, 0, len(ch.checks))
+	for _, c := range ch.checks {
+		if include(c.opts) {
+			checks = append(checks, c)
+		}
+	}
+	ch.mutex.RUnlock()
+
+	if len(checks) == 0 {
+		return nil
+	}
+
+	ret := make([]models.CheckResult, len(checks))
+	wg := sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			ret[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func (c *check) run(ctx context.Context) models.CheckResult {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.opts.CacheFor > 0 && !c.ran.IsZero() && time.Since(c.ran) < c.opts.CacheFor {
+		return c.last
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	errchan := make(chan error, 1) // buffered so a stuck check does not leak on send
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				errchan <- fmt.Errorf("check panicked: %v", err)
+			}
+		}()
+		errchan <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errchan:
+	case <-ctx.Done():
+		err = ErrCheckTimeout
+		if parent.Err() != nil {
+			err = parent.Err()
+		}
+	}
+
+	result := models.CheckResult{
+		Name:     c.name,
+		Status:   models.CheckPass,
+		Critical: c.opts.Critical,
+		Duration: float64(time.Since(start)) / float64(time.Millisecond),
+		Checked:  start.Unix(),
+	}
+	if err != nil {
+		result.Status = models.CheckFail
+		result.Error = err.Error()
+	}
+
+	// only the check's own outcome is worth reusing, not a caller that stopped waiting
+	if parent.Err() == nil {
+		c.last, c.ran = result, start
+	}
+	return result
+}
+
+// Rollup combines check results into the Down and Status fields of a health status. Any
+// failing critical check marks the client down; failing non-critical checks leave it up
+// but degraded.
+func Rollup(results []models.CheckResult) (down bool, status string) {
+	var critical, degraded []string
+	for _, result := range results {
+		if result.Status != models.CheckFail {
+			continue
+		}
+		if result.Critical {
+			critical = append(critical, result.Name)
+		} else {
+			degraded = append(degraded, result.Name)
+		}
+	}
+
+	switch {
+	case len(critical) > 0:
+		return true, "down: " + strings.Join(critical, ", ")
+	case len(degraded) > 0:
+		return false, "degraded: " + strings.Join(degraded, ", ")
+	default:
+		return false, "ok"
+	}
+}
+
+// RegisterCheck adds a check to the DefaultChecker.
+func RegisterCheck(name string, fn CheckFunc, opts CheckOptions) error {
+	return DefaultChecker.Register(name, fn, opts)
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestCheckerRun(t *testing.T) {
+	ch := MakeChecker()
+	ch.Register("database", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}, CheckOptions{Critical: true})
+	ch.Register("cache", func(ctx context.Context) error {
+		return nil
+	}, CheckOptions{})
+	ch.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}, CheckOptions{Timeout: 10 * time.Millisecond})
+
+	results := ch.Run(context.Background())
+	if len(results) != 3 {
+		t.Fatalf("wanted 3 results, got %v", results)
+	}
+
+	// sorted by name
+	cache, database, slow := results[0], results[1], results[2]
+	if cache.Name != "cache" || cache.Status != models.CheckPass {
+		t.Errorf("unexpected cache result %v", cache)
+	}
+	if database.Status != models.CheckFail || !database.Critical || database.Error != "connection refused" {
+		t.Errorf("unexpected database result %v", database)
+	}
+	if slow.Status != models.CheckFail || slow.Error != ErrCheckTimeout.Error() {
+		t.Errorf("unexpected slow result %v", slow)
+	}
+
+	down, status := Rollup(results)
+	if !down || status != "down: database" {
+		t.Errorf("wanted down: database, got %v %q", down, status)
+	}
+}
+
+func TestCheckerCache(t *testing.T) {
+	calls := 0
+	ch := MakeChecker()
+	ch.Register("counted", func(ctx context.Context) error {
+		calls++
+		return nil
+	}, CheckOptions{CacheFor: time.Minute})
+
+	ch.Run(context.Background())
+	ch.Run(context.Background())
+	if calls != 1 {
+		t.Errorf("wanted cached result to be reused, check ran %d times", calls)
+	}
+
+	if err := ch.Register("counted", nil, CheckOptions{}); err != ErrDuplicateCheck {
+		t.Errorf("wanted ErrDuplicateCheck, got %v", err)
+	}
+}
+
+func TestCheckerCacheCancelled(t *testing.T) {
+	ch := MakeChecker()
+	ch.Register("slow", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		}
+	}, CheckOptions{Timeout: time.Second, CacheFor: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if results := ch.Run(ctx); results[0].Status != models.CheckFail {
+		t.Errorf("wanted cancelled caller to see a failure, got %+v", results[0])
+	}
+	if results := ch.Run(context.Background()); results[0].Status != models.CheckPass {
+		t.Errorf("wanted the next caller to run the check rather than reuse the cancelled run, got %+v", results[0])
+	}
+}
+
+func TestRollup(t *testing.T) {
+	var testCases = []struct {
+		results []models.CheckResult
+		down    bool
+		status  string
+	}{
+		{[]models.CheckResult{{Name: "a", Status: models.CheckPass}}, false, "ok"},
+		{[]models.CheckResult{{Name: "a", Status: models.CheckFail}, {Name: "b", Status: models.CheckFail}}, false, "degraded: a, b"},
+		{[]models.CheckResult{{Name: "a", Status: models.CheckFail}, {Name: "b", Status: models.CheckFail, Critical: true}}, true, "down: b"},
+	}
+
+	for _, test := range testCases {
+		down, status := Rollup(test.results)
+		if down != test.down || status != test.status {
+			t.Errorf("wanted %v %q, got %v %q", test.down, test.status, down, status)
+		}
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
+	"github.com/markpotocki/health/internal/status"
 	"github.com/markpotocki/health/pkg/models"
 )
 
@@ -21,88 +25,227 @@ import (
 
 const Endpoint string = "/aidi"
 
+// HealthPath is where the client serves its health status for the server to poll.
+const HealthPath = "/metrics/health"
+
+// CPUSampleInterval is the window cpu utilization is measured over once connected.
+const CPUSampleInterval = 5 * time.Second
+
+// Defaults used when the matching ConnectionConfig field is zero.
+const (
+	DefaultInterval   = 10 * time.Second
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
 type ErrServerNotReady error
 type ErrResponder error
 
+// ConnectionConfig describes how to reach the aidi server and how the client serves its own
+// endpoints.
+//
+// Host and Port are the primary aidi server, left empty when every server is in Servers.
+// Servers lists any more, as "host:port", for redundancy; the client registers with every
+// one and Quorum is how many must accept before Connect returns, all of them when zero.
+// AuthHeader, when set, is sent as the Authorization header to every server.
+//
+// Interval is how often the client checks each server still knows about it; a server that
+// has restarted and forgotten the client is registered with again. Registration is retried
+// with exponential backoff between MinBackoff and MaxBackoff.
+//
+// AdvertiseURL is where servers should poll the client's health status, for clients behind
+// a proxy or NAT; by default they poll the client port at the address the client connects
+// from. Servers only accept a url at another host with their auth token in AuthHeader.
+// Instance tells this replica apart from others registering under the same name, and
+// defaults to "hostname:port". Labels and Metadata are registered with the client, labels
+// for selecting it on the server, ie env=prod, and metadata as detail such as version or
+// region. The host name is added to Metadata as "host" unless already set.
+//
+// When Mux is set the client endpoints are mounted on it and no listener is started, for
+// applications that already serve http on the client port.
 type ConnectionConfig struct {
-	Host       string
-	Port       string
-	AuthHeader string
-	Interval   time.Duration
+	Host         string
+	Port         string
+	Servers      []string
+	Quorum       int
+	AuthHeader   string
+	Instance     string
+	AdvertiseURL string
+	Labels       map[string]string
+	Metadata     map[string]string
+	Interval     time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	Mux          *http.ServeMux
 }
 
 type Client struct {
-	config ConnectionConfig
-	name   string
-	port   int
+	config     ConnectionConfig
+	name       string
+	port       int
+	collectors *Registry
+	checks     *Checker
+	draining   int32
+	started    int32
+	servers    []*registration
+	httpcli    *http.Client
 }
 
 func MakeClient(name string, port int, config ConnectionConfig) *Client {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	hostname, _ := os.Hostname()
+	if config.Instance == "" && hostname != "" {
+		config.Instance = net.JoinHostPort(hostname, strconv.Itoa(port))
+	}
+	metadata := map[string]string{}
+	for key, value := range config.Metadata {
+		metadata[key] = value
+	}
+	if _, ok := metadata["host"]; !ok && hostname != "" {
+		metadata["host"] = hostname
+	}
+	config.Metadata = metadata
+
+	addrs := config.Servers
+	if config.Host != "" || config.Port != "" {
+		addrs = append([]string{net.JoinHostPort(config.Host, config.Port)}, config.Servers...)
+	}
+	servers := make([]*registration, 0, len(addrs))
+	for _, addr := range addrs {
+		servers = append(servers, makeRegistration(addr))
+	}
 	return &Client{
-		config: config,
-		name:   name,
-		port:   port,
+		config:     config,
+		name:       name,
+		port:       port,
+		collectors: DefaultRegistry,
+		checks:     DefaultChecker,
+		servers:    servers,
+		httpcli:    &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
-func (c *Client) Connect(ctx context.Context) chan error {
-	errchan := make(chan error, 1)
-	hostURL := fmt.Sprintf("http://%s:%s", c.config.Host, c.config.Port)
-	log.Println("client: checking if connection is ready")
-	// first lets make sure the connection is valid and ready
-	// we can do this by sending the server a GET request on
-	// $Endpoint/ready
-	resp, err := http.Get(hostURL + fmt.Sprintf("%s/ready", Endpoint))
-	if err != nil {
-		panic(err) // we can connect throw an error
+// Collectors returns the registry whose collectors are reported by this client. It is the
+// DefaultRegistry unless replaced with SetCollectors.
+func (c *Client) Collectors() *Registry {
+	return c.collectors
+}
+
+// SetCollectors replaces the registry reported by this client.
+func (c *Client) SetCollectors(r *Registry) {
+	c.collectors = r
+}
+
+// Checks returns the health checks run by this client. It is the DefaultChecker unless
+// replaced with SetChecks.
+func (c *Client) Checks() *Checker {
+	return c.checks
+}
+
+// SetChecks replaces the health checks run by this client.
+func (c *Client) SetChecks(ch *Checker) {
+	c.checks = ch
+}
+
+// Connect opens the client endpoints, on ConnectionConfig.Mux or a listener of its own on
+// the client port, then registers with the aidi servers. It returns once a quorum of servers
+// have accepted the registration, or with an error if ctx is done first. Each server keeps
+// being retried and watched in the background, see Register. Everything started by Connect
+// stops when ctx is done.
+func (c *Client) Connect(ctx context.Context) error {
+	if c.config.Mux != nil {
+		c.Mount(c.config.Mux)
+	} else if err := c.listen(ctx); err != nil {
+		return err
 	}
-	if resp.StatusCode != 200 {
-		errMsg := fmt.Sprintf("Server responded with status %d", resp.StatusCode)
-		errchan <- ErrServerNotReady(errors.New(errMsg))
+
+	if err := c.Register(ctx); err != nil {
+		return err
 	}
 
-	log.Println("client: aidi server is ready")
+	// sample cpu in the background so every scrape sees the same window
+	go status.GlobalCPUSampler.Run(ctx, CPUSampleInterval)
 
-	// server is ready for our connections lets setup our pings
-	// the server does not know we are here so we will make it aware
-	log.Println("client: encoding client info to json")
-	buffer := bytes.Buffer{}
-	err = json.NewEncoder(&buffer).Encode(models.ClientInfo{CName: c.name, CPort: c.port})
-	log.Println("client: value encoded to json")
+	return nil
+}
 
-	log.Println("client: registering with aidi server")
-	resp, err = http.Post(hostURL+
-		fmt.Sprintf("%s/register", Endpoint),
-		"application/json",
-		&buffer,
-	)
+// Handler returns an http.Handler serving the health status and probe endpoints.
+func (c *Client) Handler() http.Handler {
+	mux := http.NewServeMux()
+	c.Mount(mux)
+	return mux
+}
 
+// Mount registers the health status and probe endpoints on mux.
+func (c *Client) Mount(mux *http.ServeMux) {
+	mux.HandleFunc(HealthPath, c.healthHandler)
+	mux.HandleFunc(LivezPath, c.livezHandler)
+	mux.HandleFunc(ReadyzPath, c.readyzHandler)
+	mux.HandleFunc(StartupzPath, c.startupzHandler)
+}
+
+// jitter spreads retries by up to 20% either way so clients restarted together do not
+// retry together.
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*0.4-0.2)*float64(d))
+}
+
+// do sends a request to a server, with body as json when it is not nil.
+func (c *Client) do(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
-		log.Println("client: could not connect to aidi server, panicking")
-		panic(err)
+		return nil, err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.config.AuthHeader != "" {
+		req.Header.Set("Authorization", c.config.AuthHeader)
+	}
+	return c.httpcli.Do(req.WithContext(ctx))
+}
 
-	log.Println("client: registration accepted")
-
-	// we can now listen for requests for our health
+// listen serves Handler on the client port until ctx is done. The port is bound before
+// returning so the server can poll as soon as registration succeeds.
+func (c *Client) listen(ctx context.Context) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", c.port))
+	if err != nil {
+		return err
+	}
 	log.Println("client: opening endpoint for metrics")
-	go c.responder(errchan)
 
-	return errchan
-}
-
-func (c *Client) responder(errchan chan error) {
-	healthHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		crhs := models.MakeHealthStatus()
-		jsonErr := json.NewEncoder(w).Encode(&crhs)
-		if jsonErr != nil {
-			errchan <- ErrResponder(jsonErr)
-			http.Error(w, "Failed to decode json", http.StatusInternalServerError)
-			return
+	srv := &http.Server{Handler: c.Handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Printf("client: metrics endpoint stopped -- %v", err)
 		}
-	})
-
-	http.Handle("/metrics/health", healthHandler)
+	}()
+	return nil
+}
 
-	errchan <- http.ListenAndServe(fmt.Sprintf(":%d", c.port), nil)
+func (c *Client) healthHandler(w http.ResponseWriter, r *http.Request) {
+	crhs := models.MakeHealthStatus()
+	crhs.Custom = c.collectors.Collect()
+	crhs.Checks = c.checks.Run(r.Context())
+	if len(crhs.Checks) > 0 {
+		crhs.Down, crhs.Status = Rollup(crhs.Checks)
+	}
+	jsonErr := json.NewEncoder(w).Encode(&crhs)
+	if jsonErr != nil {
+		log.Printf("client: %v", ErrResponder(jsonErr))
+		http.Error(w, "Failed to decode json", http.StatusInternalServerError)
+		return
+	}
 }
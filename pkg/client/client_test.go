@@ -0,0 +1,323 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// fakeServer is an aidi server that is not ready for the first notReady calls to /ready.
+// It knows about a client once registered, until forget is called. A legacy server only
+// has the unversioned registration endpoint. The versioned one tells clients it polls every
+// poll seconds.
+type fakeServer struct {
+	legacy     bool
+	poll       float64
+	notReady   int32
+	registered int32
+	known      int32
+	auth       atomic.Value
+	info       atomic.Value
+}
+
+func (fs *fakeServer) forget() {
+	atomic.StoreInt32(&fs.known, 0)
+}
+
+func (fs *fakeServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/aidi/ready", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fs.notReady, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/aidi/register", func(w http.ResponseWriter, r *http.Request) {
+		fs.auth.Store(r.Header.Get("Authorization"))
+		info := models.ClientInfo{}
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil || info.CName == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fs.info.Store(info)
+		atomic.AddInt32(&fs.registered, 1)
+		atomic.StoreInt32(&fs.known, 1)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/aidi/v1/register", func(w http.ResponseWriter, r *http.Request) {
+		if fs.legacy {
+			http.NotFound(w, r)
+			return
+		}
+		fs.auth.Store(r.Header.Get("Authorization"))
+		req := models.RegisterRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if errs := req.Validate(); len(errs) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.ErrorResponse{Error: models.APIError{Code: models.ErrCodeValidation, Fields: errs}})
+			return
+		}
+		info := models.ClientInfo{CName: req.Name, Instance: req.Instance, CPort: req.Port, CURL: req.URL, Labels: req.Labels, Metadata: req.Metadata}
+		fs.info.Store(info)
+		atomic.AddInt32(&fs.registered, 1)
+		atomic.StoreInt32(&fs.known, 1)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.RegisterResponse{ID: info.ID(), URL: info.URL(), PollInterval: fs.poll})
+	})
+	mux.HandleFunc("/aidi/health/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fs.known) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func testConfig(t *testing.T, url string) ConnectionConfig {
+	host, port, err := net.SplitHostPort(addr(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ConnectionConfig{
+		Host:       host,
+		Port:       port,
+		AuthHeader: "Bearer token",
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		Mux:        http.NewServeMux(),
+	}
+}
+
+func addr(url string) string {
+	return url[len("http://"):]
+}
+
+func TestRegisterRetries(t *testing.T) {
+	fs := &fakeServer{notReady: 3}
+	srv := httptest.NewServer(fs.handler())
+	defer srv.Close()
+
+	config := testConfig(t, srv.URL)
+	config.Labels = map[string]string{"env": "prod"}
+	config.Metadata = map[string]string{"version": "1.2.0"}
+	cli := MakeClient("orders", 8080, config)
+	if err := cli.Register(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if registered := atomic.LoadInt32(&fs.registered); registered != 1 {
+		t.Errorf("wanted 1 registration, got %d", registered)
+	}
+	if auth := fs.auth.Load(); auth != "Bearer token" {
+		t.Errorf("wanted auth header sent, got %v", auth)
+	}
+	info := fs.info.Load().(models.ClientInfo)
+	if info.Labels["env"] != "prod" || info.Metadata["version"] != "1.2.0" || info.Metadata["host"] == "" {
+		t.Errorf("wanted labels and metadata with host sent, got %+v", info)
+	}
+	if host, port, err := net.SplitHostPort(info.Instance); err != nil || host != info.Metadata["host"] || port != "8080" {
+		t.Errorf("wanted instance derived from host and port, got %q", info.Instance)
+	}
+}
+
+func TestRegisterV1(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		fs := &fakeServer{legacy: legacy, poll: 1}
+		srv := httptest.NewServer(fs.handler())
+
+		config := testConfig(t, srv.URL)
+		config.Instance = "orders-1"
+		config.AdvertiseURL = "https://orders.example.com/metrics/health"
+		cli := MakeClient("orders", 8080, config)
+		if err := cli.Register(context.Background()); err != nil {
+			t.Fatalf("legacy %t: unexpected error: %v", legacy, err)
+		}
+		srv.Close()
+
+		status := cli.Servers()[0]
+		if status.ID != "orders@orders-1" {
+			t.Errorf("legacy %t: wanted id orders@orders-1, got %q", legacy, status.ID)
+		}
+		if info := fs.info.Load().(models.ClientInfo); info.URL() != config.AdvertiseURL {
+			t.Errorf("legacy %t: wanted advertised url sent, got %q", legacy, info.URL())
+		}
+		// only the versioned api says how often it polls
+		if expect := map[bool]time.Duration{false: time.Second, true: 0}[legacy]; status.PollInterval != expect {
+			t.Errorf("legacy %t: wanted poll interval %v, got %v", legacy, expect, status.PollInterval)
+		}
+	}
+}
+
+func TestRegisterRejected(t *testing.T) {
+	fs := &fakeServer{}
+	srv := httptest.NewServer(fs.handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cli := MakeClient("not a name", 8080, testConfig(t, srv.URL))
+	if err := cli.Register(ctx); err == nil {
+		t.Fatal("wanted error for invalid registration")
+	}
+	if err := cli.Servers()[0].Err; err == nil || !strings.Contains(err.Error(), "name") {
+		t.Errorf("wanted rejection naming the field, got %v", err)
+	}
+}
+
+func TestRegisterGivesUp(t *testing.T) {
+	fs := &fakeServer{notReady: 1 << 30}
+	srv := httptest.NewServer(fs.handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	cli := MakeClient("orders", 8080, testConfig(t, srv.URL))
+	if err := cli.Register(ctx); err == nil {
+		t.Error("wanted error once context is done")
+	}
+	if registered := atomic.LoadInt32(&fs.registered); registered != 0 {
+		t.Errorf("wanted no registration while server not ready, got %d", registered)
+	}
+}
+
+func TestConnectUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	config := testConfig(t, srv.URL)
+	srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// returns an error rather than panicking
+	if err := MakeClient("orders", 8080, config).Connect(ctx); err == nil {
+		t.Error("wanted error for unreachable server")
+	}
+}
+
+func TestReregisterWhenForgotten(t *testing.T) {
+	fs := &fakeServer{poll: 0.005}
+	srv := httptest.NewServer(fs.handler())
+	defer srv.Close()
+
+	config := testConfig(t, srv.URL)
+	config.Interval = 5 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cli := MakeClient("orders", 8080, config)
+	if err := cli.Connect(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// still known, so no new registration
+	time.Sleep(50 * time.Millisecond)
+	if registered := atomic.LoadInt32(&fs.registered); registered != 1 {
+		t.Errorf("wanted 1 registration while known, got %d", registered)
+	}
+
+	// the server restarts and loses its clients
+	fs.forget()
+	time.Sleep(100 * time.Millisecond)
+	if registered := atomic.LoadInt32(&fs.registered); registered < 2 {
+		t.Errorf("wanted client to register again, got %d registrations", registered)
+	}
+}
+
+func TestRegisterQuorum(t *testing.T) {
+	up1, up2 := &fakeServer{}, &fakeServer{}
+	srv1 := httptest.NewServer(up1.handler())
+	defer srv1.Close()
+	srv2 := httptest.NewServer(up2.handler())
+	defer srv2.Close()
+	down := &fakeServer{notReady: 1 << 30}
+	srv3 := httptest.NewServer(down.handler())
+	defer srv3.Close()
+
+	var testCases = []struct {
+		name   string
+		quorum int
+		expect bool
+	}{
+		{"all", 0, false},
+		{"majority", 2, true},
+		{"toomany", 5, false},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(t, srv1.URL)
+			config.Servers = []string{addr(srv2.URL), addr(srv3.URL)}
+			config.Quorum = test.quorum
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			cli := MakeClient("orders", 8080, config)
+			err := cli.Register(ctx)
+			if ok := err == nil; ok != test.expect {
+				t.Fatalf("wanted registered %t, got error %v", test.expect, err)
+			}
+
+			servers := cli.Servers()
+			if len(servers) != 3 {
+				t.Fatalf("wanted 3 servers, got %d", len(servers))
+			}
+			for i, healthy := range []bool{true, true, false} {
+				if servers[i].Healthy != healthy {
+					t.Errorf("%s: wanted healthy %t, got %+v", servers[i].Addr, healthy, servers[i])
+				}
+			}
+			if servers[2].Err == nil {
+				t.Error("wanted failure recorded for server that is not ready")
+			}
+		})
+	}
+}
+
+func TestServersWithoutPrimary(t *testing.T) {
+	up := &fakeServer{}
+	srv := httptest.NewServer(up.handler())
+	defer srv.Close()
+
+	config := testConfig(t, srv.URL)
+	config.Host, config.Port = "", ""
+	config.Servers = []string{addr(srv.URL)}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cli := MakeClient("orders", 8080, config)
+	if err := cli.Register(ctx); err != nil {
+		t.Fatalf("wanted registered with the only server, got %v", err)
+	}
+	if servers := cli.Servers(); len(servers) != 1 || servers[0].Addr != addr(srv.URL) {
+		t.Errorf("wanted only the listed server, got %+v", servers)
+	}
+
+	config.Servers = nil
+	if err := MakeClient("orders", 8080, config).Register(ctx); err != ErrNoServers {
+		t.Errorf("wanted ErrNoServers, got %v", err)
+	}
+}
+
+func TestMountedHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	cli := MakeClient("orders", 8080, ConnectionConfig{})
+	cli.SetChecks(MakeChecker())
+	cli.SetCollectors(MakeRegistry())
+	cli.Mount(mux)
+
+	for _, path := range []string{HealthPath, LivezPath, ReadyzPath, StartupzPath} {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: wanted 200, got %d", path, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// ErrDuplicateCollector is returned when a collector is registered under a name that is
+// already in use.
+var ErrDuplicateCollector = errors.New("client: collector already registered")
+
+// Collector is implemented by anything that wants to publish its own metrics alongside the
+// built in health data. Collect is called on every health request, so it should be cheap.
+type Collector interface {
+	Collect() []models.Metric
+}
+
+// CollectorFunc allows a plain function to be used as a Collector.
+type CollectorFunc func() []models.Metric
+
+// Collect calls f.
+func (f CollectorFunc) Collect() []models.Metric {
+	return f()
+}
+
+// MakeGauge provides a gauge metric, a value that can go up and down.
+func MakeGauge(name string, val float64, labels map[string]string) models.Metric {
+	return models.Metric{Name: name, Type: models.Gauge, Value: val, Labels: labels}
+}
+
+// MakeCounter provides a counter metric, a value that only increases.
+func MakeCounter(name string, val float64, labels map[string]string) models.Metric {
+	return models.Metric{Name: name, Type: models.Counter, Value: val, Labels: labels}
+}
+
+// Registry holds the named collectors whose output is reported in the custom section of
+// the health status.
+type Registry struct {
+	collectors map[string]Collector
+	mutex      sync.RWMutex
+}
+
+// DefaultRegistry is the registry used by clients made with MakeClient.
+var DefaultRegistry = MakeRegistry()
+
+// MakeRegistry provides an empty Registry.
+func MakeRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]Collector),
+	}
+}
+
+// Register adds a collector under name. ErrDuplicateCollector is returned if the name is
+// taken.
+func (r *Registry) Register(name string, c Collector) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.collectors[name]; ok {
+		return ErrDuplicateCollector
+	}
+	r.collectors[name] = c
+	return nil
+}
+
+// Unregister removes the collector under name, if there is one.
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	delete(r.collectors, name)
+	r.mutex.Unlock()
+}
+
+// Names returns the registered collector names in sorted order.
+func (r *Registry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collect runs every collector and returns the results keyed by collector name. A
+// collector that panics is logged and left out rather than failing the whole report.
+func (r *Registry) Collect() map[string][]models.Metric {
+	r.mutex.RLock()
+	collectors := make(map[string]Collector, len(r.collectors))
+	for name, c := range r.collectors {
+		collectors[name] = c
+	}
+	r.mutex.RUnlock()
+
+	if len(collectors) == 0 {
+		return nil
+	}
+	ret := make(map[string][]models.Metric, len(collectors))
+	for name, c := range collectors {
+		if metrics, ok := safeCollect(name, c); ok {
+			ret[name] = metrics
+		}
+	}
+	return ret
+}
+
+func safeCollect(name string, c Collector) (metrics []models.Metric, ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("client: collector %s panicked -- %v", name, err)
+			ok = false
+		}
+	}()
+	return c.Collect(), true
+}
+
+// Register adds a collector to the DefaultRegistry.
+func Register(name string, c Collector) error {
+	return DefaultRegistry.Register(name, c)
+}
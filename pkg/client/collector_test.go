@@ -0,0 +1,62 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+func TestRegistryCollect(t *testing.T) {
+	reg := MakeRegistry()
+	err := reg.Register("queue", CollectorFunc(func() []models.Metric {
+		return []models.Metric{
+			MakeGauge("depth", 12, map[string]string{"queue": "orders"}),
+			MakeCounter("processed", 300, nil),
+		}
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := reg.Register("queue", CollectorFunc(nil)); err != ErrDuplicateCollector {
+		t.Errorf("wanted ErrDuplicateCollector, got %v", err)
+	}
+
+	custom := reg.Collect()
+	metrics := custom["queue"]
+	if len(metrics) != 2 {
+		t.Fatalf("wanted 2 metrics, got %v", custom)
+	}
+	if metrics[0].Type != models.Gauge || metrics[0].Labels["queue"] != "orders" || metrics[0].Value != 12 {
+		t.Errorf("unexpected gauge %v", metrics[0])
+	}
+	if metrics[1].Type != models.Counter {
+		t.Errorf("unexpected counter %v", metrics[1])
+	}
+
+	reg.Unregister("queue")
+	if custom := reg.Collect(); custom != nil {
+		t.Errorf("wanted no metrics after unregister, got %v", custom)
+	}
+}
+
+func TestRegistryPanickingCollector(t *testing.T) {
+	reg := MakeRegistry()
+	reg.Register("bad", CollectorFunc(func() []models.Metric {
+		panic("boom")
+	}))
+	reg.Register("good", CollectorFunc(func() []models.Metric {
+		return []models.Metric{MakeGauge("ok", 1, nil)}
+	}))
+
+	custom := reg.Collect()
+	if _, ok := custom["bad"]; ok {
+		t.Error("panicking collector should be left out")
+	}
+	if len(custom["good"]) != 1 {
+		t.Errorf("wanted good collector to report, got %v", custom)
+	}
+	if names := reg.Names(); len(names) != 2 || names[0] != "bad" {
+		t.Errorf("unexpected names %v", names)
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// Probe endpoints served next to /metrics/health for orchestrators such as kubernetes. Add
+// ?verbose to any of them for a line per check.
+const (
+	LivezPath    = "/livez"
+	ReadyzPath   = "/readyz"
+	StartupzPath = "/startupz"
+)
+
+// Drain marks the client not ready so /readyz fails and traffic is moved away before
+// shutdown. The health checks and /livez are unaffected.
+func (c *Client) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+// Undrain reverses Drain.
+func (c *Client) Undrain() {
+	atomic.StoreInt32(&c.draining, 0)
+}
+
+// Draining reports whether Drain has been called.
+func (c *Client) Draining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+// Started reports whether every critical check has passed at least once. Once started, the
+// client stays started.
+func (c *Client) Started() bool {
+	return atomic.LoadInt32(&c.started) == 1
+}
+
+// livezHandler fails only when a check registered with Liveness fails.
+func (c *Client) livezHandler(w http.ResponseWriter, r *http.Request) {
+	results := c.checks.RunLiveness(r.Context())
+	writeProbe(w, r, "livez", results, !anyFailed(results, false))
+}
+
+// readyzHandler fails while draining or when any critical check fails.
+func (c *Client) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	results := c.checks.Run(r.Context())
+	ready := !anyFailed(results, true)
+	if ready {
+		atomic.StoreInt32(&c.started, 1)
+	}
+	if c.Draining() {
+		results = append(results, models.CheckResult{
+			Name:   "draining",
+			Status: models.CheckFail,
+			Error:  "client is draining",
+		})
+		ready = false
+	}
+	writeProbe(w, r, "readyz", results, ready)
+}
+
+// startupzHandler passes once every critical check has passed at least once.
+func (c *Client) startupzHandler(w http.ResponseWriter, r *http.Request) {
+	if c.Started() {
+		writeProbe(w, r, "startupz", nil, true)
+		return
+	}
+	results := c.checks.Run(r.Context())
+	started := !anyFailed(results, true)
+	if started {
+		atomic.StoreInt32(&c.started, 1)
+	}
+	writeProbe(w, r, "startupz", results, started)
+}
+
+func anyFailed(results []models.CheckResult, criticalOnly bool) bool {
+	for _, result := range results {
+		if result.Status == models.CheckFail && (result.Critical || !criticalOnly) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeProbe writes the plain text probe format used by kubernetes components: "ok" or a
+// failure line, or with ?verbose a [+]/[-] line for every check first.
+func writeProbe(w http.ResponseWriter, r *http.Request, probe string, results []models.CheckResult, ok bool) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_, verbose := r.URL.Query()["verbose"]
+	if !verbose {
+		if ok {
+			fmt.Fprint(w, "ok")
+		} else {
+			fmt.Fprintf(w, "%s check failed", probe)
+		}
+		return
+	}
+
+	buf := strings.Builder{}
+	for _, result := range results {
+		if result.Status == models.CheckFail {
+			fmt.Fprintf(&buf, "[-]%s failed: %s\n", result.Name, result.Error)
+		} else {
+			fmt.Fprintf(&buf, "[+]%s ok\n", result.Name)
+		}
+	}
+	if ok {
+		fmt.Fprintf(&buf, "%s check passed\n", probe)
+	} else {
+		fmt.Fprintf(&buf, "%s check failed\n", probe)
+	}
+	fmt.Fprint(w, buf.String())
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func probe(t *testing.T, handler http.HandlerFunc, target string) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+	resp := recorder.Result()
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestProbes(t *testing.T) {
+	var dbErr error
+	checks := MakeChecker()
+	checks.Register("database", func(ctx context.Context) error {
+		return dbErr
+	}, CheckOptions{Critical: true})
+	checks.Register("deadlock", func(ctx context.Context) error {
+		return nil
+	}, CheckOptions{Liveness: true})
+
+	cli := MakeClient("test", 0, ConnectionConfig{})
+	cli.SetChecks(checks)
+
+	// dependency down: alive but not ready or started
+	dbErr = errors.New("unreachable")
+	if code, body := probe(t, cli.livezHandler, "/livez"); code != 200 || body != "ok" {
+		t.Errorf("livez: wanted 200 ok, got %d %q", code, body)
+	}
+	if code, body := probe(t, cli.readyzHandler, "/readyz"); code != 503 || body != "readyz check failed" {
+		t.Errorf("readyz: wanted 503, got %d %q", code, body)
+	}
+	code, body := probe(t, cli.startupzHandler, "/startupz?verbose")
+	expect := "[-]database failed: unreachable\n[+]deadlock ok\nstartupz check failed\n"
+	if code != 503 || body != expect {
+		t.Errorf("startupz: wanted 503 %q, got %d %q", expect, code, body)
+	}
+
+	// dependency back: ready and started
+	dbErr = nil
+	if code, _ := probe(t, cli.readyzHandler, "/readyz"); code != 200 {
+		t.Errorf("readyz: wanted 200, got %d", code)
+	}
+	if !cli.Started() {
+		t.Error("expected client to be started after passing readiness")
+	}
+
+	// draining fails readiness only, startup stays latched
+	cli.Drain()
+	code, body = probe(t, cli.readyzHandler, "/readyz?verbose")
+	expect = "[+]database ok\n[+]deadlock ok\n[-]draining failed: client is draining\nreadyz check failed\n"
+	if code != 503 || body != expect {
+		t.Errorf("readyz draining: wanted 503 %q, got %d %q", expect, code, body)
+	}
+	dbErr = errors.New("unreachable")
+	if code, _ := probe(t, cli.startupzHandler, "/startupz"); code != 200 {
+		t.Errorf("startupz: wanted latched 200, got %d", code)
+	}
+	if code, _ := probe(t, cli.livezHandler, "/livez"); code != 200 {
+		t.Errorf("livez: wanted 200 while draining, got %d", code)
+	}
+}
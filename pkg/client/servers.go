@@ -0,0 +1,300 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/markpotocki/health/pkg/models"
+)
+
+// ErrNoQuorum is returned by Register when ctx is done before enough servers accepted the
+// registration.
+var ErrNoQuorum = errors.New("client: could not register with a quorum of servers")
+
+// ErrNoServers is returned by Register when the ConnectionConfig names no aidi server.
+var ErrNoServers = errors.New("client: no servers configured")
+
+// ServerStatus is the state of the client's registration with a single aidi server.
+// Registered is when the server last accepted the registration, Seen when it last confirmed
+// it still knew about the client, and Err the last failure talking to it. ID is what the
+// server knows the client by and PollInterval how often it polls, zero when not told.
+type ServerStatus struct {
+	Addr         string
+	ID           string
+	PollInterval time.Duration
+	Healthy      bool
+	Registered   time.Time
+	Seen         time.Time
+	Err          error
+}
+
+// registration tracks the client's standing with one server. ready is closed the first time
+// the server accepts the registration.
+type registration struct {
+	addr      string
+	status    ServerStatus
+	running   bool
+	ready     chan struct{}
+	readyOnce sync.Once
+	mutex     sync.Mutex
+}
+
+func makeRegistration(addr string) *registration {
+	return &registration{addr: addr, ready: make(chan struct{})}
+}
+
+func (reg *registration) update(fn func(*ServerStatus)) {
+	reg.mutex.Lock()
+	fn(&reg.status)
+	reg.mutex.Unlock()
+}
+
+func (reg *registration) get() ServerStatus {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	ret := reg.status
+	ret.Addr = reg.addr
+	return ret
+}
+
+// Servers reports the registration state with every configured server.
+func (c *Client) Servers() []ServerStatus {
+	ret := make([]ServerStatus, 0, len(c.servers))
+	for _, reg := range c.servers {
+		ret = append(ret, reg.get())
+	}
+	return ret
+}
+
+func (c *Client) quorum() int {
+	if c.config.Quorum <= 0 || c.config.Quorum > len(c.servers) {
+		return len(c.servers)
+	}
+	return c.config.Quorum
+}
+
+// Register tells every aidi server about this client and blocks until a quorum have
+// accepted, or returns ErrNoQuorum once ctx is done. Each server is handled on its own: a
+// registration that fails is retried with exponential backoff, and once registered the
+// server is checked every Interval and registered with again if it has forgotten the client.
+// This continues in the background until ctx is done.
+func (c *Client) Register(ctx context.Context) error {
+	if len(c.servers) == 0 {
+		return ErrNoServers
+	}
+	accepted := make(chan struct{}, len(c.servers))
+	for _, reg := range c.servers {
+		reg.mutex.Lock()
+		running := reg.running
+		reg.running = true
+		reg.mutex.Unlock()
+		// a server already maintained by an earlier Register only needs waiting on
+		if !running {
+			go c.maintain(ctx, reg)
+		}
+		go func(reg *registration) {
+			select {
+			case <-reg.ready:
+				accepted <- struct{}{}
+			case <-ctx.Done():
+			}
+		}(reg)
+	}
+
+	need := c.quorum()
+	for got := 0; got < need; got++ {
+		select {
+		case <-accepted:
+		case <-ctx.Done():
+			return ErrNoQuorum
+		}
+	}
+	return nil
+}
+
+// maintain keeps the client registered with a single server until ctx is done.
+func (c *Client) maintain(ctx context.Context, reg *registration) {
+	defer func() {
+		reg.mutex.Lock()
+		reg.running = false
+		reg.mutex.Unlock()
+	}()
+
+	if !c.registerWithBackoff(ctx, reg) {
+		return
+	}
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if known := c.verify(ctx, reg); known {
+				continue
+			}
+			log.Printf("client: server %s has forgotten us, registering again", reg.addr)
+			if !c.registerWithBackoff(ctx, reg) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// registerWithBackoff retries register until it succeeds, reporting false if ctx is done
+// first.
+func (c *Client) registerWithBackoff(ctx context.Context, reg *registration) bool {
+	backoff := c.config.MinBackoff
+	for {
+		accepted, err := c.register(ctx, reg.addr)
+		if err == nil {
+			now := time.Now()
+			reg.update(func(status *ServerStatus) {
+				status.Healthy, status.Registered, status.Seen, status.Err = true, now, now, nil
+				status.ID = accepted.ID
+				status.PollInterval = time.Duration(accepted.PollInterval * float64(time.Second))
+			})
+			reg.readyOnce.Do(func() { close(reg.ready) })
+			log.Printf("client: registration accepted by %s", reg.addr)
+			return true
+		}
+		reg.update(func(status *ServerStatus) {
+			status.Healthy, status.Err = false, err
+		})
+		log.Printf("client: registration with %s failed, retrying in %v -- %v", reg.addr, backoff, err)
+
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-ctx.Done():
+			return false
+		}
+		if backoff *= 2; backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+}
+
+func serverURL(addr, path string) string {
+	return fmt.Sprintf("http://%s%s%s", addr, Endpoint, path)
+}
+
+func (c *Client) register(ctx context.Context, addr string) (models.RegisterResponse, error) {
+	// first lets make sure the connection is valid and ready
+	// we can do this by sending the server a GET request on
+	// $Endpoint/ready
+	resp, err := c.do(ctx, "GET", serverURL(addr, "/ready"), nil)
+	if err != nil {
+		return models.RegisterResponse{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return models.RegisterResponse{}, ErrServerNotReady(fmt.Errorf("server responded with status %d", resp.StatusCode))
+	}
+
+	// the server does not know we are here so we will make it aware
+	req := models.RegisterRequest{
+		Name:     c.name,
+		Instance: c.config.Instance,
+		Port:     c.port,
+		URL:      c.config.AdvertiseURL,
+		Labels:   c.config.Labels,
+		Metadata: c.config.Metadata,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return models.RegisterResponse{}, err
+	}
+	resp, err = c.do(ctx, "POST", serverURL(addr, "/v1/register"), body)
+	if err != nil {
+		return models.RegisterResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// a server from before the versioned api
+		return c.registerLegacy(ctx, addr, req)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		apiErr := models.ErrorResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error.Code == "" {
+			return models.RegisterResponse{}, fmt.Errorf("registration rejected with status %d", resp.StatusCode)
+		}
+		return models.RegisterResponse{}, fmt.Errorf("registration rejected: %v %v", apiErr.Error, apiErr.Error.Fields)
+	}
+
+	accepted := models.RegisterResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&accepted); err != nil {
+		return models.RegisterResponse{}, err
+	}
+	return accepted, nil
+}
+
+// registerLegacy registers on /aidi/register, which does not answer with an ID, so the one
+// the server would derive is assumed.
+func (c *Client) registerLegacy(ctx context.Context, addr string, req models.RegisterRequest) (models.RegisterResponse, error) {
+	body, err := json.Marshal(models.ClientInfo{
+		CName:    req.Name,
+		Instance: req.Instance,
+		CPort:    req.Port,
+		CURL:     req.URL,
+		Labels:   req.Labels,
+		Metadata: req.Metadata,
+	})
+	if err != nil {
+		return models.RegisterResponse{}, err
+	}
+	resp, err := c.do(ctx, "POST", serverURL(addr, "/register"), body)
+	if err != nil {
+		return models.RegisterResponse{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return models.RegisterResponse{}, fmt.Errorf("registration rejected with status %d", resp.StatusCode)
+	}
+	return models.RegisterResponse{ID: models.InstanceID(req.Name, req.Instance), URL: req.URL}, nil
+}
+
+// verify asks the server for the client's status. It reports false only when the server
+// answers that it does not know the client, which after a restart is the case until the
+// client registers again. A new registration is given three intervals, ours or the
+// server's poll interval if longer, for the first poll to land before a missing status
+// counts. An unreachable server is marked unhealthy but left alone; when it comes back it
+// will either still know the client or answer not found.
+func (c *Client) verify(ctx context.Context, reg *registration) bool {
+	registered := reg.get()
+	resp, err := c.do(ctx, "GET", serverURL(reg.addr, "/health/"+registered.ID), nil)
+	if err != nil {
+		reg.update(func(status *ServerStatus) {
+			status.Healthy, status.Err = false, err
+		})
+		return true
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		reg.update(func(status *ServerStatus) {
+			status.Healthy, status.Seen, status.Err = true, time.Now(), nil
+		})
+		return true
+	case resp.StatusCode == http.StatusNotFound:
+		grace := c.config.Interval
+		if registered.PollInterval > grace {
+			grace = registered.PollInterval
+		}
+		return time.Since(registered.Registered) < 3*grace
+	default:
+		err := fmt.Errorf("server responded with status %d", resp.StatusCode)
+		reg.update(func(status *ServerStatus) {
+			status.Healthy, status.Err = false, err
+		})
+		return true
+	}
+}
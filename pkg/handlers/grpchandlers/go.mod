@@ -0,0 +1,21 @@
+module github.com/markpotocki/health/pkg/handlers/grpchandlers
+
+go 1.17
+
+require (
+	github.com/markpotocki/health v0.0.0-00010101000000-000000000000
+	google.golang.org/grpc v1.56.3
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+)
+
+// built and tested against this checkout of health; require a tagged release in place of
+// the replace when publishing
+replace github.com/markpotocki/health => ../../..
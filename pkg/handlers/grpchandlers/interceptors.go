@@ -0,0 +1,55 @@
+// Package grpchandlers provides gRPC server interceptors that feed the same latency, error
+// rate and request counts as the pkg/handlers http middleware. It is its own module so the
+// rest of health does not depend on gRPC.
+package grpchandlers
+
+import (
+	"context"
+
+	"github.com/markpotocki/health/pkg/handlers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Method names used in the request counts, in place of the http method.
+const (
+	UnaryMethod  = "grpc-unary"
+	StreamMethod = "grpc-stream"
+)
+
+// UnaryServerInterceptor records every unary call under its full method name, ie
+// "/orders.Orders/Get", with the gRPC code as the status class.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done := handlers.Track(UnaryMethod, info.FullMethod)
+		resp, err := handler(ctx, req)
+		code := status.Code(err)
+		done(code.String(), 0, IsServerError(code))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records every streaming call under its full method name. The
+// latency covers the whole life of the stream.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done := handlers.Track(StreamMethod, info.FullMethod)
+		err := handler(srv, ss)
+		code := status.Code(err)
+		done(code.String(), 0, IsServerError(code))
+		return err
+	}
+}
+
+// IsServerError reports whether a code is the server's fault, the gRPC equivalent of a 5xx.
+// These count towards the error rate; client errors such as NotFound do not.
+func IsServerError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal,
+		codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
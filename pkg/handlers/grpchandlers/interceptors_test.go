@@ -0,0 +1,73 @@
+package grpchandlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/markpotocki/health/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// findCount reads the calls to route with class from the reported health status.
+func findCount(route, class string) uint64 {
+	for _, count := range models.MakeHealthStatus().Network.Requests {
+		if count.Route == route && count.Class == class {
+			return count.Count
+		}
+	}
+	return 0
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/orders.Orders/Get"}
+
+	var testCases = []error{
+		nil,
+		status.Error(codes.NotFound, "no such order"),
+		status.Error(codes.Unavailable, "database down"),
+	}
+	for _, want := range testCases {
+		_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, want
+		})
+		if err != want {
+			t.Errorf("wanted handler error %v passed through, got %v", want, err)
+		}
+	}
+
+	for _, class := range []string{"OK", "NotFound", "Unavailable"} {
+		if count := findCount("/orders.Orders/Get", class); count != 1 {
+			t.Errorf("%s: wanted 1 call, got %d", class, count)
+		}
+	}
+
+	for _, summary := range models.MakeHealthStatus().Network.Latency {
+		if summary.Route != "/orders.Orders/Get" {
+			continue
+		}
+		// only Unavailable is the server's fault
+		if summary.Count != 3 || summary.Errors != 1 {
+			t.Errorf("wanted 3 calls with 1 error, got %+v", summary)
+		}
+		return
+	}
+	t.Error("method missing from latency tracker")
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/orders.Orders/Watch"}
+
+	err := interceptor(nil, nil, info, func(srv interface{}, stream grpc.ServerStream) error {
+		return status.Error(codes.Internal, "boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("wanted handler error passed through, got %v", err)
+	}
+	if count := findCount("/orders.Orders/Watch", "Internal"); count != 1 {
+		t.Errorf("wanted 1 call, got %d", count)
+	}
+}
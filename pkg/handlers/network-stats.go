@@ -1,30 +1,131 @@
 package handlers
 
 import (
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/markpotocki/health/internal/status"
 )
 
+// maxPathRoutes bounds how many distinct request paths ResponseTimer records. Requests to
+// any path past that are recorded under OtherRoute, so paths holding ids cannot grow the
+// counts without limit.
+const maxPathRoutes = 100
+
+// OtherRoute is the route ResponseTimer records requests under once maxPathRoutes paths
+// have been seen.
+const OtherRoute = "other"
+
+// pathRoutes remembers the request paths ResponseTimer has recorded.
+var pathRoutes = struct {
+	seen  map[string]bool
+	mutex sync.Mutex
+}{seen: make(map[string]bool)}
+
+// pathRoute is the route a request to path is recorded under by ResponseTimer.
+func pathRoute(path string) string {
+	pathRoutes.mutex.Lock()
+	defer pathRoutes.mutex.Unlock()
+	if pathRoutes.seen[path] {
+		return path
+	}
+	if len(pathRoutes.seen) >= maxPathRoutes {
+		return OtherRoute
+	}
+	pathRoutes.seen[path] = true
+	return path
+}
+
+// ResponseTimer records how long next takes to reply, keyed by the request path. Only the
+// first maxPathRoutes paths get their own route, the rest share OtherRoute; use RouteTimer
+// instead when the path contains ids so every request is not its own route.
 func ResponseTimer(next http.Handler) http.Handler {
-	times := make(chan int, 100) // buffer a bit for multiple requests at same time
-	go ongoingAverage(times)
+	return RouteTimer("", next)
+}
+
+// RouteTimer records every request to next under route, or as ResponseTimer does when route
+// is empty: the time taken to reply, the method and status class, the size of the response and
+// whether it is still in flight. These feed the latency, error rate and request counts
+// reported in the health status. Any 5xx response counts as an error, as does a panic in
+// next, which is recorded as a 500 before it carries on up the stack.
+func RouteTimer(route string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := unixMilli()
-		next.ServeHTTP(w, r)
-		end := unixMilli()
-		times <- int(end - start)
+		name := route
+		if name == "" {
+			name = pathRoute(r.URL.Path)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		done := Track(r.Method, name)
+		defer func() {
+			p := recover()
+			if p != nil {
+				rec.code = http.StatusInternalServerError
+			}
+			done(status.StatusClass(rec.code), rec.size, rec.code >= 500)
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
 	})
 }
 
-func ongoingAverage(times <-chan int) {
-	for time := range times {
-		status.GlobalNetworkInformation.AddVal(time)
+// Track marks a call to route as in flight and returns the func to record it once it has
+// finished, with its status class, response size and whether it failed. It lets transports
+// other than net/http feed the same latency, error rate and request counts as RouteTimer.
+func Track(method, route string) func(class string, size int64, failed bool) {
+	start := time.Now()
+	status.GlobalRequestTracker.Begin()
+	return func(class string, size int64, failed bool) {
+		elapsed := time.Since(start)
+		status.GlobalRequestTracker.DoneClass(method, route, class, size)
+		status.GlobalLatencyTracker.Record(route, elapsed, failed)
+		status.GlobalNetworkInformation.AddVal(int(elapsed / time.Millisecond))
 	}
 }
 
-func unixMilli() int64 {
-	nano := time.Now().UnixNano()
-	return nano / int64(time.Millisecond)
+// statusRecorder keeps the status code and body size written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	size        int64
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.code = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += int64(n)
+	return n, err
+}
+
+// Flush passes through to the wrapped writer so streaming handlers keep working.
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack passes through to the wrapped writer so websocket and other upgrading handlers keep
+// working.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("handlers: the response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
 }
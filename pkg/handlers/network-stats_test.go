@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/internal/status"
+)
+
+func findCount(route, method, class string) status.RequestCount {
+	for _, count := range status.GlobalRequestTracker.Counts() {
+		if count.Route == route && count.Method == method && count.Class == class {
+			return count
+		}
+	}
+	return status.RequestCount{}
+}
+
+func TestRouteTimer(t *testing.T) {
+	inFlight := make(chan int64, 1)
+	handler := RouteTimer("/orders/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight <- status.GlobalRequestTracker.InFlight()
+		if r.URL.Path == "/orders/broken" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+
+	for _, path := range []string{"/orders/1", "/orders/2", "/orders/broken"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if flight := <-inFlight; flight < 1 {
+			t.Errorf("wanted request in flight while serving, got %d", flight)
+		}
+	}
+
+	ok := findCount("/orders/{id}", "GET", "2xx")
+	if ok.Count != 2 || ok.Bytes != 10 {
+		t.Errorf("wanted 2 ok requests of 10 bytes, got %+v", ok)
+	}
+	if failed := findCount("/orders/{id}", "GET", "5xx"); failed.Count != 1 {
+		t.Errorf("wanted 1 failed request, got %+v", failed)
+	}
+	if flight := status.GlobalRequestTracker.InFlight(); flight != 0 {
+		t.Errorf("wanted nothing in flight after, got %d", flight)
+	}
+
+	for _, summary := range status.GlobalLatencyTracker.Snapshot(time.Minute) {
+		if summary.Route != "/orders/{id}" {
+			continue
+		}
+		if summary.Count != 3 || summary.Errors != 1 {
+			t.Errorf("wanted 3 requests with 1 error, got %+v", summary)
+		}
+		return
+	}
+	t.Error("route missing from latency tracker")
+}
+
+func TestRouteTimerPanic(t *testing.T) {
+	handler := RouteTimer("/panics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("wanted the panic passed on, got %v", p)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panics", nil))
+	}()
+
+	if failed := findCount("/panics", "GET", "5xx"); failed.Count != 1 {
+		t.Errorf("wanted the panic counted as a 5xx, got %+v", failed)
+	}
+}
+
+func TestRouteTimerHijack(t *testing.T) {
+	srv := httptest.NewServer(RouteTimer("/upgrade", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("wanted the connection hijacked, got %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		buf.Flush()
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("wanted 101 from the hijacked connection, got %d", resp.StatusCode)
+	}
+}
+
+func TestResponseTimerUsesPath(t *testing.T) {
+	handler := ResponseTimer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/aidi/missing", nil))
+
+	if missing := findCount("/aidi/missing", "POST", "4xx"); missing.Count != 1 {
+		t.Errorf("wanted request counted under its path, got %+v", missing)
+	}
+}
+
+func TestResponseTimerBoundsPaths(t *testing.T) {
+	handler := ResponseTimer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < maxPathRoutes+10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/aidi/health/client-"+strconv.Itoa(i), nil))
+	}
+
+	paths := 0
+	for _, count := range status.GlobalRequestTracker.Counts() {
+		if strings.HasPrefix(count.Route, "/aidi/health/") {
+			paths++
+		}
+	}
+	if paths > maxPathRoutes {
+		t.Errorf("wanted at most %d paths recorded, got %d", maxPathRoutes, paths)
+	}
+	if other := findCount(OtherRoute, "GET", "2xx"); other.Count < 10 {
+		t.Errorf("wanted paths past the limit counted under %s, got %+v", OtherRoute, other)
+	}
+}
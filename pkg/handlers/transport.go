@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/markpotocki/health/internal/status"
+)
+
+// TransportErrorClass is the status class recorded for outgoing calls that failed before a
+// response was received, such as connection refused or a timeout.
+const TransportErrorClass = "error"
+
+// DependencyTimer wraps next, or http.DefaultTransport when nil, to record every outgoing
+// call by host: the time taken, the method and status class, and whether it failed. These
+// are reported in the dependencies section of the health status. A call fails when no
+// response is received or the response is a 5xx.
+func DependencyTimer(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		start := time.Now()
+		status.GlobalDependencyRequests.Begin()
+
+		resp, err := next.RoundTrip(r)
+
+		elapsed := time.Since(start)
+		host := r.URL.Host
+		if err != nil {
+			status.GlobalDependencyRequests.DoneClass(r.Method, host, TransportErrorClass, 0)
+			status.GlobalDependencyLatency.Record(host, elapsed, true)
+			return resp, err
+		}
+		status.GlobalDependencyRequests.Done(r.Method, host, resp.StatusCode, resp.ContentLength)
+		status.GlobalDependencyLatency.Record(host, elapsed, resp.StatusCode >= 500)
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/markpotocki/health/internal/status"
+)
+
+func TestDependencyTimer(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	host := mustHost(t, backend.URL)
+
+	cli := http.Client{Transport: DependencyTimer(nil)}
+	for _, path := range []string{"/ok", "/fail"} {
+		resp, err := cli.Get(backend.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	// nothing is listening once closed
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadHost := mustHost(t, dead.URL)
+	dead.Close()
+	if _, err := cli.Get(dead.URL); err == nil {
+		t.Fatal("wanted error calling closed server")
+	}
+
+	counts := map[string]uint64{}
+	for _, count := range status.GlobalDependencyRequests.Counts() {
+		counts[count.Route+" "+count.Class] = count.Count
+	}
+	var testCases = []struct {
+		key    string
+		expect uint64
+	}{
+		{host + " 2xx", 1},
+		{host + " 5xx", 1},
+		{deadHost + " " + TransportErrorClass, 1},
+	}
+	for _, test := range testCases {
+		if counts[test.key] != test.expect {
+			t.Errorf("%s: wanted %d, got %d", test.key, test.expect, counts[test.key])
+		}
+	}
+
+	for _, summary := range status.GlobalDependencyLatency.Snapshot(time.Minute) {
+		if summary.Route == host && (summary.Count != 2 || summary.Errors != 1) {
+			t.Errorf("wanted 2 calls with 1 error to %s, got %+v", host, summary)
+		}
+	}
+}
+
+func mustHost(t *testing.T, raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
@@ -0,0 +1,37 @@
+package models
+
+// ClientStatus is the latest health of one client as served by the /aidi/v1 api. Updated is
+// the unix time it was polled; Instance, Origin, Labels and Metadata are copied from the
+// client's ClientInfo. Maintenance is the ID of the Maintenance window the client was in
+// when polled.
+type ClientStatus struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Instance    string            `json:"instance,omitempty"`
+	Health      HealthStatus      `json:"health"`
+	Updated     int64             `json:"updated"`
+	Origin      string            `json:"origin,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Maintenance string            `json:"maintenance,omitempty"`
+}
+
+// Page describes which part of a list a paged /aidi/v1 call returned. Total counts every
+// item matching the query, before Offset and Limit are applied.
+type Page struct {
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// ClientList is a page of /aidi/v1/clients.
+type ClientList struct {
+	Items []ClientInfo `json:"items"`
+	Page  Page         `json:"page"`
+}
+
+// StatusList is a page of /aidi/v1/statuses.
+type StatusList struct {
+	Items []ClientStatus `json:"items"`
+	Page  Page           `json:"page"`
+}
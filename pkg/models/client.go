@@ -1,16 +1,53 @@
 package models
 
+// OriginRegistered is the Origin of a client that registered itself with the server.
+const OriginRegistered = "registered"
+
+// ClientInfo describes a client the server polls. Origin is OriginRegistered for clients
+// that registered themselves, or the name of the discoverer that found them. When Probe is
+// set the server runs it instead of fetching a HealthStatus from the url.
+//
+// Several instances of one service share a name and are told apart by Instance, see ID.
+//
+// Labels are what clients are grouped and selected by, ie env or team, and are limited to
+// the characters a Selector accepts. Metadata is free form detail such as version, host or
+// region, reported but not selectable.
 type ClientInfo struct {
-	CName string `json:"name"`
-	CPort int    `json:"port"`
-	CURL  string `json:"url"`
-	Key   string `json:"key"`
+	CName    string            `json:"name"`
+	Instance string            `json:"instance,omitempty"`
+	CPort    int               `json:"port"`
+	CURL     string            `json:"url"`
+	Key      string            `json:"key"`
+	Origin   string            `json:"origin,omitempty"`
+	Probe    *Probe            `json:"probe,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 func (ci ClientInfo) Name() string {
 	return ci.CName
 }
 
+// ID identifies the client among every instance of every service, as "name@instance", or
+// just the name for a client without an instance.
+func (ci ClientInfo) ID() string {
+	return InstanceID(ci.CName, ci.Instance)
+}
+
+// InstanceID joins a service name and instance into the ID of a single client.
+func InstanceID(name, instance string) string {
+	if instance == "" {
+		return name
+	}
+	return name + "@" + instance
+}
+
 func (ci ClientInfo) URL() string {
 	return ci.CURL
 }
+
+// Discovered reports whether the client was found by service discovery rather than
+// registering itself.
+func (ci ClientInfo) Discovered() bool {
+	return ci.Origin != "" && ci.Origin != OriginRegistered
+}
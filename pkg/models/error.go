@@ -0,0 +1,35 @@
+package models
+
+// Codes of an APIError.
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeValidation       = "validation_failed"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeInternal         = "internal"
+	ErrCodeNotImplemented   = "not_implemented"
+	ErrCodeUnauthorized     = "unauthorized"
+)
+
+// ErrorResponse is the body of every failed versioned api call.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes why a versioned api call failed. Code is one of the ErrCode
+// constants; Fields lists the problems with each part of the request when validation fails.
+type APIError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is a problem with one field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (err APIError) Error() string {
+	return err.Code + ": " + err.Message
+}
@@ -0,0 +1,89 @@
+package models
+
+import "time"
+
+// StatusMaintenance is the Status of a client polled during a Maintenance window.
+const StatusMaintenance = "maintenance"
+
+// Maintenance is a window during which clients are expected to go down, ie for a deploy.
+// The server keeps polling them, reporting them up with StatusMaintenance and marking
+// their statuses with the window's ID. A window
+// covers the clients of service Client, or those with an ID of Client, and the clients
+// whose labels match Selector; with both set a client has to match both.
+type Maintenance struct {
+	ID       string    `json:"id"`
+	Client   string    `json:"client,omitempty"`
+	Selector string    `json:"selector,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Reason   string    `json:"reason"`
+	Created  time.Time `json:"created"`
+}
+
+// MaintenanceRequest is the body of a call scheduling a Maintenance window. Start defaults
+// to the time of the call.
+type MaintenanceRequest struct {
+	Client   string    `json:"client,omitempty"`
+	Selector string    `json:"selector,omitempty"`
+	Start    time.Time `json:"start,omitempty"`
+	End      time.Time `json:"end"`
+	Reason   string    `json:"reason"`
+}
+
+// MaintenanceList is a page of scheduled maintenance windows.
+type MaintenanceList struct {
+	Items []Maintenance `json:"items"`
+	Page  Page          `json:"page"`
+}
+
+// Validate checks the request as of now, returning every problem found.
+func (req MaintenanceRequest) Validate(now time.Time) []FieldError {
+	errs := []FieldError{}
+	if req.Client == "" && req.Selector == "" {
+		errs = append(errs, FieldError{Field: "client", Message: "client or selector is required"})
+	}
+	if _, err := ParseSelector(req.Selector); err != nil {
+		errs = append(errs, FieldError{Field: "selector", Message: err.Error()})
+	}
+	if req.Reason == "" {
+		errs = append(errs, FieldError{Field: "reason", Message: "is required"})
+	}
+	start := req.Start
+	if start.IsZero() {
+		start = now
+	}
+	switch {
+	case req.End.IsZero():
+		errs = append(errs, FieldError{Field: "end", Message: "is required"})
+	case !req.End.After(start):
+		errs = append(errs, FieldError{Field: "end", Message: "must be after start"})
+	case !req.End.After(now):
+		errs = append(errs, FieldError{Field: "end", Message: "must be in the future"})
+	}
+	return errs
+}
+
+// Active reports whether the window is open at t.
+func (m Maintenance) Active(t time.Time) bool {
+	return !t.Before(m.Start) && t.Before(m.End)
+}
+
+// Expired reports whether the window has closed by t.
+func (m Maintenance) Expired(t time.Time) bool {
+	return !t.Before(m.End)
+}
+
+// Covers reports whether the window applies to the client of the given service name, ID and
+// labels.
+func (m Maintenance) Covers(name, id string, labels map[string]string) bool {
+	if m.Client != "" && m.Client != name && m.Client != id {
+		return false
+	}
+	if m.Selector != "" {
+		sel, err := ParseSelector(m.Selector)
+		if err != nil || !sel.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
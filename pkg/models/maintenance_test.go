@@ -0,0 +1,58 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceValidate(t *testing.T) {
+	now := time.Now()
+	var testCases = []struct {
+		req    MaintenanceRequest
+		fields string
+	}{
+		{MaintenanceRequest{Client: "orders", End: now.Add(time.Hour), Reason: "deploy"}, ""},
+		{MaintenanceRequest{Selector: "env=prod", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour), Reason: "deploy"}, ""},
+		{MaintenanceRequest{End: now.Add(time.Hour)}, "client,reason"},
+		{MaintenanceRequest{Selector: "=prod", End: now.Add(time.Hour), Reason: "deploy"}, "selector"},
+		{MaintenanceRequest{Client: "orders", Reason: "deploy"}, "end"},
+		{MaintenanceRequest{Client: "orders", Start: now.Add(2 * time.Hour), End: now.Add(time.Hour), Reason: "deploy"}, "end"},
+		{MaintenanceRequest{Client: "orders", Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour), Reason: "deploy"}, "end"},
+	}
+	for _, test := range testCases {
+		fields := []string{}
+		for _, err := range test.req.Validate(now) {
+			fields = append(fields, err.Field)
+		}
+		if strings.Join(fields, ",") != test.fields {
+			t.Errorf("%+v: wanted problems with %q, got %v", test.req, test.fields, fields)
+		}
+	}
+}
+
+func TestMaintenanceCovers(t *testing.T) {
+	prod := map[string]string{"env": "prod"}
+	var testCases = []struct {
+		window Maintenance
+		covers bool
+	}{
+		{Maintenance{Client: "orders"}, true},
+		{Maintenance{Client: "orders@orders-1"}, true},
+		{Maintenance{Client: "orders@orders-2"}, false},
+		{Maintenance{Selector: "env=prod"}, true},
+		{Maintenance{Client: "orders", Selector: "env=staging"}, false},
+		{Maintenance{Client: "payments", Selector: "env=prod"}, false},
+	}
+	for _, test := range testCases {
+		if covers := test.window.Covers("orders", "orders@orders-1", prod); covers != test.covers {
+			t.Errorf("%+v: wanted covers %t, got %t", test.window, test.covers, covers)
+		}
+	}
+
+	now := time.Now()
+	window := Maintenance{Start: now, End: now.Add(time.Hour)}
+	if !window.Active(now) || window.Active(now.Add(time.Hour)) || window.Expired(now) || !window.Expired(now.Add(time.Hour)) {
+		t.Errorf("wanted window open from start until end, got %+v", window)
+	}
+}
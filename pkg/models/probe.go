@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+)
+
+// Kinds of blackbox probe.
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeDNS  = "dns"
+)
+
+// DefaultCertExpiryDays is how many days an https certificate must have left when a probe
+// does not say.
+const DefaultCertExpiryDays = 14
+
+// MaxProbeTimeout is the longest Timeout a probe may set, in milliseconds.
+const MaxProbeTimeout = 5000
+
+// Probe describes a blackbox check the server runs against a target that cannot serve a
+// HealthStatus itself. Target is a url for http, "host:port" for tcp and a host name for
+// dns. An http probe passes on ExpectStatus, any 2xx when zero, and when set a body
+// matching the ExpectBody regular expression; https targets also need a certificate valid
+// for CertExpiryDays more days. Timeout is in milliseconds, at most MaxProbeTimeout.
+type Probe struct {
+	Kind           string `json:"kind"`
+	Target         string `json:"target"`
+	ExpectStatus   int    `json:"expect_status,omitempty"`
+	ExpectBody     string `json:"expect_body,omitempty"`
+	CertExpiryDays int    `json:"cert_expiry_days,omitempty"`
+	Timeout        int    `json:"timeout,omitempty"`
+}
+
+// Validate reports whether the probe can be run.
+func (p Probe) Validate() error {
+	switch p.Kind {
+	case ProbeHTTP:
+		u, err := url.Parse(p.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("http probe target %q is not an http(s) url", p.Target)
+		}
+		if _, err := regexp.Compile(p.ExpectBody); err != nil {
+			return fmt.Errorf("http probe body %q: %v", p.ExpectBody, err)
+		}
+	case ProbeTCP:
+		if _, _, err := net.SplitHostPort(p.Target); err != nil {
+			return fmt.Errorf("tcp probe target %q: %v", p.Target, err)
+		}
+	case ProbeDNS:
+		if p.Target == "" {
+			return fmt.Errorf("dns probe needs a host name")
+		}
+	default:
+		return fmt.Errorf("unknown probe kind %q", p.Kind)
+	}
+	if p.Timeout < 0 || p.CertExpiryDays < 0 || p.ExpectStatus < 0 {
+		return fmt.Errorf("probe settings may not be negative")
+	}
+	if p.Timeout > MaxProbeTimeout {
+		return fmt.Errorf("probe timeout may not be over %dms", MaxProbeTimeout)
+	}
+	return nil
+}
+
+// ProbeResult is the detail of a probe run, reported in place of the process stats a
+// client would send. Duration is in milliseconds. CertExpiry is the unix time the https
+// certificate expires and Addresses what a dns probe resolved to.
+type ProbeResult struct {
+	Kind       string   `json:"kind"`
+	Target     string   `json:"target"`
+	Duration   float64  `json:"duration"`
+	StatusCode int      `json:"status_code,omitempty"`
+	CertExpiry int64    `json:"cert_expiry,omitempty"`
+	Addresses  []string `json:"addresses,omitempty"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProbeValidate(t *testing.T) {
+	var testCases = []struct {
+		probe Probe
+		valid bool
+	}{
+		{Probe{Kind: ProbeHTTP, Target: "https://example.com/health"}, true},
+		{Probe{Kind: ProbeHTTP, Target: "example.com/health"}, false},
+		{Probe{Kind: ProbeHTTP, Target: "http://example.com", ExpectBody: "("}, false},
+		{Probe{Kind: ProbeTCP, Target: "[::1]:5432"}, true},
+		{Probe{Kind: ProbeTCP, Target: "db"}, false},
+		{Probe{Kind: ProbeDNS, Target: "example.com"}, true},
+		{Probe{Kind: ProbeDNS}, false},
+		{Probe{Kind: "icmp", Target: "example.com"}, false},
+		{Probe{Kind: ProbeTCP, Target: "db:5432", Timeout: -1}, false},
+		{Probe{Kind: ProbeTCP, Target: "db:5432", Timeout: MaxProbeTimeout}, true},
+		{Probe{Kind: ProbeTCP, Target: "db:5432", Timeout: MaxProbeTimeout + 1}, false},
+	}
+	for _, test := range testCases {
+		if err := test.probe.Validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: wanted valid %t, got %v", test.probe, test.valid, err)
+		}
+	}
+}
+
+func TestProbeJSON(t *testing.T) {
+	body := `{"kind":"http","target":"https://example.com","expect_status":204,"expect_body":"ok","cert_expiry_days":14}`
+	probe := Probe{}
+	if err := json.Unmarshal([]byte(body), &probe); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := Probe{Kind: ProbeHTTP, Target: "https://example.com", ExpectStatus: 204, ExpectBody: "ok", CertExpiryDays: 14}
+	if probe != expect {
+		t.Errorf("wanted %+v, got %+v", expect, probe)
+	}
+
+	out, _ := json.Marshal(ProbeResult{Kind: ProbeHTTP, Target: "https://example.com", Duration: 12, StatusCode: 204, CertExpiry: 1600000000})
+	if string(out) != `{"kind":"http","target":"https://example.com","duration":12,"status_code":204,"cert_expiry":1600000000}` {
+		t.Errorf("wanted snake_case result, got %s", out)
+	}
+}
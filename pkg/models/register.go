@@ -0,0 +1,74 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// namePattern is what service names may contain. "@" and "/" are left out as they separate
+// the parts of an ID and of a path.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.\-]{0,126}[A-Za-z0-9])?$`)
+
+// RegisterRequest is the body of a /aidi/v1/register call. The server polls URL when set,
+// for clients behind a proxy or NAT, though a URL at a host other than the caller's needs the
+// server's auth token; otherwise it polls Port on the address the request came from.
+// Instance defaults to that address. Probe targets need neither.
+type RegisterRequest struct {
+	Name     string            `json:"name"`
+	Instance string            `json:"instance,omitempty"`
+	Port     int               `json:"port,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Probe    *Probe            `json:"probe,omitempty"`
+}
+
+// RegisterResponse is returned by a successful /aidi/v1/register call. ID is what the client
+// is known by, URL where it will be polled and PollInterval how often, in seconds.
+type RegisterResponse struct {
+	ID           string  `json:"id"`
+	URL          string  `json:"url"`
+	PollInterval float64 `json:"poll_interval"`
+}
+
+// Validate checks the request, returning every problem found.
+func (req RegisterRequest) Validate() []FieldError {
+	errs := []FieldError{}
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !namePattern.MatchString(req.Name) {
+		add("name", "must be 1 to 128 letters, digits, '.', '_' or '-', starting and ending with a letter or digit")
+	}
+	if strings.ContainsAny(req.Instance, "/@ ") || len(req.Instance) > 255 {
+		add("instance", "may not contain '/', '@' or spaces, or be longer than 255")
+	}
+	if req.Port < 0 || req.Port > 65535 {
+		add("port", "must be between 1 and 65535")
+	}
+	if req.URL != "" {
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("url", "must be an absolute http or https url")
+		}
+	}
+
+	switch {
+	case req.Probe != nil:
+		if req.URL != "" || req.Port != 0 {
+			add("probe", "cannot be combined with url or port")
+		} else if err := req.Probe.Validate(); err != nil {
+			add("probe", "%v", err)
+		}
+	case req.URL == "" && req.Port == 0:
+		add("port", "is required unless url or probe is given")
+	}
+
+	if err := ValidateLabels(req.Labels); err != nil {
+		add("labels", "%v", err)
+	}
+	return errs
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Selector operators.
+const (
+	SelectEquals    = "="
+	SelectNotEquals = "!="
+	SelectExists    = "exists"
+	SelectNotExists = "!exists"
+)
+
+// labelPattern is what label keys and values may contain.
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.\-/]*[A-Za-z0-9])?$`)
+
+// Requirement is a single condition of a Selector.
+type Requirement struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// Selector picks clients by their labels. Every requirement must hold for a match.
+type Selector []Requirement
+
+// ParseSelector reads a comma separated list of requirements, each one of "key=value",
+// "key==value", "key!=value", "key" for the label being set or "!key" for it being unset,
+// ie "env=prod,team!=payments". An empty string selects everything.
+func ParseSelector(s string) (Selector, error) {
+	sel := Selector{}
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		var req Requirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			req = Requirement{strings.TrimSpace(kv[0]), SelectNotEquals, strings.TrimSpace(kv[1])}
+		case strings.Contains(part, "=="):
+			kv := strings.SplitN(part, "==", 2)
+			req = Requirement{strings.TrimSpace(kv[0]), SelectEquals, strings.TrimSpace(kv[1])}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			req = Requirement{strings.TrimSpace(kv[0]), SelectEquals, strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(part, "!"):
+			req = Requirement{Key: strings.TrimSpace(part[1:]), Operator: SelectNotExists}
+		default:
+			req = Requirement{Key: part, Operator: SelectExists}
+		}
+		if !labelPattern.MatchString(req.Key) {
+			return nil, fmt.Errorf("invalid label key %q in selector", req.Key)
+		}
+		if (req.Operator == SelectEquals || req.Operator == SelectNotEquals) && req.Value != "" && !labelPattern.MatchString(req.Value) {
+			return nil, fmt.Errorf("invalid label value %q in selector", req.Value)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// Matches reports whether labels satisfy every requirement. A missing label counts as the
+// empty string for "=" and "!=".
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, req := range sel {
+		value, ok := labels[req.Key]
+		switch req.Operator {
+		case SelectEquals:
+			if value != req.Value {
+				return false
+			}
+		case SelectNotEquals:
+			if value == req.Value {
+				return false
+			}
+		case SelectExists:
+			if !ok {
+				return false
+			}
+		case SelectNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String formats the selector so it parses back to the same requirements.
+func (sel Selector) String() string {
+	parts := make([]string, 0, len(sel))
+	for _, req := range sel {
+		switch req.Operator {
+		case SelectExists:
+			parts = append(parts, req.Key)
+		case SelectNotExists:
+			parts = append(parts, "!"+req.Key)
+		default:
+			parts = append(parts, req.Key+req.Operator+req.Value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ValidateLabels checks that every key and value in labels may be used in a selector.
+// Values may be empty.
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !labelPattern.MatchString(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if value != "" && !labelPattern.MatchString(value) {
+			return fmt.Errorf("invalid value %q for label %s", value, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	var testCases = []struct {
+		selector string
+		expect   string
+		valid    bool
+	}{
+		{"", "", true},
+		{"env=prod,team!=payments", "env=prod,team!=payments", true},
+		{" env == prod , canary ", "env=prod,canary", true},
+		{"!canary,region=", "!canary,region=", true},
+		{"example.com/tier=web", "example.com/tier=web", true},
+		{"=prod", "", false},
+		{"env=prod,", "", false},
+		{"env=pr od", "", false},
+	}
+	for _, test := range testCases {
+		sel, err := ParseSelector(test.selector)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: wanted valid %t, got %v", test.selector, test.valid, err)
+			continue
+		}
+		if err == nil && sel.String() != test.expect {
+			t.Errorf("%q: wanted %q, got %q", test.selector, test.expect, sel.String())
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "orders"}
+	var testCases = []struct {
+		selector string
+		expect   bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=prod,team!=payments", true},
+		{"env=prod,team=payments", false},
+		{"team", true},
+		{"!team", false},
+		{"canary", false},
+		{"!canary", true},
+		{"region=", true},
+		{"region!=", false},
+	}
+	for _, test := range testCases {
+		sel, err := ParseSelector(test.selector)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", test.selector, err)
+		}
+		if sel.Matches(labels) != test.expect {
+			t.Errorf("%q: wanted %t", test.selector, test.expect)
+		}
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	if err := ValidateLabels(map[string]string{"env": "prod", "canary": ""}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, labels := range []map[string]string{{"": "prod"}, {"env": "prod,dev"}, {"bad key": "x"}} {
+		if err := ValidateLabels(labels); err == nil {
+			t.Errorf("%v: wanted error", labels)
+		}
+	}
+}
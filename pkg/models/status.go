@@ -2,7 +2,10 @@ package models
 
 // #include "../../c/cpu_timer.h"
 import (
+	"log"
+	"math"
 	"runtime"
+	"time"
 
 	"github.com/markpotocki/health/internal/status"
 )
@@ -11,24 +14,200 @@ type HealthStatus struct {
 	CPU     HealthStatusCpu     `json:"cpu"`
 	Memory  HealthStatusMem     `json:"mem"`
 	Network HealthStatusNetwork `json:"network"`
+	Process HealthStatusProcess `json:"process"`
 	Down    bool                `json:"down"`
 	Status  string              `json:"status"`
+
+	// Dependencies describes the outgoing calls made through the pkg/handlers transport.
+	Dependencies HealthStatusDependencies `json:"dependencies"`
+
+	// Checks are the results of the application defined health checks. A failing critical
+	// check sets Down.
+	Checks []CheckResult `json:"checks,omitempty"`
+
+	// Custom holds the metrics published by application collectors, keyed by collector name.
+	Custom map[string][]Metric `json:"custom,omitempty"`
+
+	// Container is set when the cpu and memory numbers come from cgroup limits rather than
+	// the host.
+	Container bool `json:"container"`
+
+	// Probe is set instead of the process stats when the status comes from a blackbox probe.
+	Probe *ProbeResult `json:"probe,omitempty"`
+}
+
+// Metric types reported in the custom section.
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+// Metric is a single application defined value. Labels distinguish series that share a
+// name, ie queue depth per queue.
+type Metric struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Value  float64           `json:"value"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Outcomes of a health check.
+const (
+	CheckPass = "pass"
+	CheckFail = "fail"
+)
+
+// CheckResult is the outcome of a single application defined health check. Duration is in
+// milliseconds and Checked is the unix time the check last ran, which can be older than the
+// report when the result is cached.
+type CheckResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Critical bool    `json:"critical"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"`
+	Checked  int64   `json:"checked"`
 }
 
 type HealthStatusMem struct {
 	ProcUsed  uint64 `json:"proc_used"`
 	ProcTotal uint64 `json:"proc_total"`
 	SysTotal  uint64 `json:"sys_total"`
+	Limit     uint64 `json:"limit"`
+	Used      uint64 `json:"used"`
 }
 
 type HealthStatusCpu struct {
-	Cores           int    `json:"cores"`
-	Utilization     uint   `json:"use"`
-	CoreUtilization []uint `json:"core_util"`
+	Cores           int     `json:"cores"`
+	Utilization     uint    `json:"use"`
+	CoreUtilization []uint  `json:"core_util"`
+	Load1           float64 `json:"load1"`
+	Load5           float64 `json:"load5"`
+	Load15          float64 `json:"load15"`
+	Uptime          float64 `json:"uptime"` // seconds since the host booted
+
+	// States breaks Utilization down by cpu state, CoreStates does the same for each entry
+	// of CoreUtilization. Utilization counts all but idle time, so iowait is busy there and
+	// only told apart in States.
+	States     HealthStatusCpuStates   `json:"states"`
+	CoreStates []HealthStatusCpuStates `json:"core_states"`
+
+	// cgroup cpu controller, only set when running in a container
+	Quota            float64 `json:"quota,omitempty"`
+	Periods          uint64  `json:"periods,omitempty"`
+	ThrottledPeriods uint64  `json:"throttled_periods,omitempty"`
+	ThrottledTime    float64 `json:"throttled_time,omitempty"`
+}
+
+// HealthStatusCpuStates is the percentage of time a cpu spent in each state.
+type HealthStatusCpuStates struct {
+	User    float64 `json:"user"`
+	Nice    float64 `json:"nice"`
+	System  float64 `json:"system"`
+	Idle    float64 `json:"idle"`
+	IOWait  float64 `json:"iowait"`
+	IRQ     float64 `json:"irq"`
+	SoftIRQ float64 `json:"softirq"`
+	Steal   float64 `json:"steal"`
+}
+
+// HealthStatusProcess is the resource usage of the monitored process itself. CPU is a
+// percentage of a single core.
+type HealthStatusProcess struct {
+	CPU        float64 `json:"cpu"`
+	Threads    int     `json:"threads"`
+	FDs        int     `json:"fds"`
+	Goroutines int     `json:"goroutines"`
 }
 
 type HealthStatusNetwork struct {
-	AverageTime float64 `json:"avg_response"`
+	AverageTime float64                 `json:"avg_response"`
+	Interfaces  []HealthStatusInterface `json:"interfaces,omitempty"`
+	TCPStates   map[string]int          `json:"tcp_states,omitempty"`
+	Latency     []HealthStatusLatency   `json:"latency,omitempty"`
+	Requests    []HealthStatusRequests  `json:"requests,omitempty"`
+	InFlight    int64                   `json:"in_flight"`
+}
+
+// HealthStatusRequests is the running total of requests to a route with the same method and
+// status class ("2xx", "5xx", ...). Bytes is the total size of the response bodies.
+type HealthStatusRequests struct {
+	Method string `json:"method"`
+	Route  string `json:"route"`
+	Class  string `json:"class"`
+	Count  uint64 `json:"count"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// HealthStatusLatency summarizes the requests to a route over a sliding window. Route "*" is
+// every route combined. Window and the durations are in milliseconds, Rate is per second and
+// ErrorRate is the fraction of requests that failed (5xx for http).
+type HealthStatusLatency struct {
+	Route     string  `json:"route"`
+	Window    float64 `json:"window"`
+	Count     uint64  `json:"count"`
+	Rate      float64 `json:"rate"`
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Mean      float64 `json:"mean"`
+	P50       float64 `json:"p50"`
+	P90       float64 `json:"p90"`
+	P99       float64 `json:"p99"`
+	Max       float64 `json:"max"`
+}
+
+// HealthStatusDependencies describes the outgoing calls the client has made, keyed by host
+// in place of route.
+type HealthStatusDependencies struct {
+	Latency  []HealthStatusLatency  `json:"latency,omitempty"`
+	Requests []HealthStatusRequests `json:"requests,omitempty"`
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func latencySummaries(tracker *status.LatencyTracker) []HealthStatusLatency {
+	ret := []HealthStatusLatency{}
+	for _, window := range status.LatencyWindows {
+		for _, summary := range tracker.Snapshot(window) {
+			ret = append(ret, HealthStatusLatency{
+				Route:     summary.Route,
+				Window:    millis(summary.Window),
+				Count:     summary.Count,
+				Rate:      summary.Rate,
+				Errors:    summary.Errors,
+				ErrorRate: summary.ErrorRate,
+				Mean:      millis(summary.Mean),
+				P50:       millis(summary.P50),
+				P90:       millis(summary.P90),
+				P99:       millis(summary.P99),
+				Max:       millis(summary.Max),
+			})
+		}
+	}
+	return ret
+}
+
+func requestCounts(tracker *status.RequestTracker) []HealthStatusRequests {
+	ret := []HealthStatusRequests{}
+	for _, count := range tracker.Counts() {
+		ret = append(ret, HealthStatusRequests(count))
+	}
+	return ret
+}
+
+// HealthStatusInterface is the per second traffic on a single network interface of the host.
+type HealthStatusInterface struct {
+	Name      string  `json:"name"`
+	RxBytes   float64 `json:"rx_bytes"`
+	RxPackets float64 `json:"rx_packets"`
+	RxErrors  float64 `json:"rx_errors"`
+	RxDrops   float64 `json:"rx_drops"`
+	TxBytes   float64 `json:"tx_bytes"`
+	TxPackets float64 `json:"tx_packets"`
+	TxErrors  float64 `json:"tx_errors"`
+	TxDrops   float64 `json:"tx_drops"`
 }
 
 func MakeHealthStatus() HealthStatus {
@@ -42,26 +221,109 @@ func MakeHealthStatus() HealthStatus {
 
 	// CPU
 	cpuCores := runtime.NumCPU()
-	cpuUtil := status.CPUUtilization()
+	cpuUtil, err := status.CPUUtilization()
+	if err != nil {
+		log.Printf("models: could not read cpu utilization -- %v", err)
+	}
+	load, err := status.ReadLoadAverage("/proc")
+	if err != nil {
+		log.Printf("models: could not read load average -- %v", err)
+	}
+	uptime, err := status.ReadUptime("/proc")
+	if err != nil {
+		log.Printf("models: could not read uptime -- %v", err)
+	}
+
+	coreStates := make([]HealthStatusCpuStates, 0, len(cpuUtil.CoreStates))
+	for _, states := range cpuUtil.CoreStates {
+		coreStates = append(coreStates, HealthStatusCpuStates(states))
+	}
+
+	// CONTAINER
+	cgroup, err := status.GlobalCgroupSampler.Sample()
+	if err != nil && err != status.ErrNoCgroup {
+		log.Printf("models: could not read cgroup stats -- %v", err)
+	}
+	memLimit, memUsed := cgroup.MemoryLimit, cgroup.MemoryUsage
+	if memLimit == 0 {
+		host, err := status.ReadMemInfo("/proc")
+		if err != nil {
+			log.Printf("models: could not read meminfo -- %v", err)
+		}
+		memLimit, memUsed = host.Total, host.Total-host.Available
+	}
+
+	// PROCESS
+	proc, err := status.GlobalProcessSampler.Sample()
+	if err != nil {
+		log.Printf("models: could not read process stats -- %v", err)
+	}
 
 	// NETWORK
 	averageResponse := status.GlobalNetworkInformation.Average()
+	ifaceStats, err := status.GlobalNetworkSampler.Interfaces()
+	if err != nil {
+		log.Printf("models: could not read interface stats -- %v", err)
+	}
+	interfaces := make([]HealthStatusInterface, 0, len(ifaceStats))
+	for _, iface := range ifaceStats {
+		interfaces = append(interfaces, HealthStatusInterface(iface))
+	}
+	tcpStates, err := status.GlobalNetworkSampler.TCPStates()
+	if err != nil {
+		log.Printf("models: could not read tcp states -- %v", err)
+	}
 
 	hs := HealthStatus{
 		CPU: HealthStatusCpu{
 			Cores:           cpuCores,
 			Utilization:     cpuUtil.Total,
 			CoreUtilization: cpuUtil.Cores,
+			States:          HealthStatusCpuStates(cpuUtil.States),
+			CoreStates:      coreStates,
+			Load1:           load.Load1,
+			Load5:           load.Load5,
+			Load15:          load.Load15,
+			Uptime:          uptime.Seconds(),
 		},
 		Memory: HealthStatusMem{
 			ProcUsed:  heapUsedMem,
 			ProcTotal: heapTotalMem,
 			SysTotal:  heapSysTotalMem,
+			Limit:     memLimit,
+			Used:      memUsed,
 		},
 		Network: HealthStatusNetwork{
 			AverageTime: averageResponse,
+			Interfaces:  interfaces,
+			TCPStates:   tcpStates,
+			Latency:     latencySummaries(status.GlobalLatencyTracker),
+			Requests:    requestCounts(status.GlobalRequestTracker),
+			InFlight:    status.GlobalRequestTracker.InFlight(),
+		},
+		Dependencies: HealthStatusDependencies{
+			Latency:  latencySummaries(status.GlobalDependencyLatency),
+			Requests: requestCounts(status.GlobalDependencyRequests),
+		},
+		Process: HealthStatusProcess{
+			CPU:        proc.CPU,
+			Threads:    proc.Threads,
+			FDs:        proc.FDs,
+			Goroutines: runtime.NumGoroutine(),
 		},
 	}
 
+	if cgroup.Limited() {
+		hs.Container = true
+		hs.CPU.Periods = cgroup.Periods
+		hs.CPU.ThrottledPeriods = cgroup.ThrottledPeriods
+		hs.CPU.ThrottledTime = cgroup.ThrottledTime.Seconds()
+	}
+	if cgroup.CPUQuota > 0 {
+		hs.CPU.Quota = cgroup.CPUQuota
+		hs.CPU.Cores = int(math.Ceil(cgroup.CPUQuota))
+		hs.CPU.Utilization = uint(cgroup.CPUUsage)
+	}
+
 	return hs
 }